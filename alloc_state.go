@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/core"
+	"github.com/ledgerwatch/erigon/core/state"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+	"github.com/ledgerwatch/erigon/crypto"
+)
+
+// state reader backed by an in-memory allocation (genesis alloc, prestate),
+// used instead of chaindata in tests and for offline fixtures
+type alloc_reader struct {
+	alloc core.GenesisAlloc
+}
+
+func new_alloc_reader(alloc core.GenesisAlloc) *alloc_reader {
+	return &alloc_reader{alloc: alloc}
+}
+
+// intra block state on top of the allocation
+func new_alloc_state(alloc core.GenesisAlloc) *state.IntraBlockState {
+	return state.New(new_alloc_reader(alloc))
+}
+
+func (r *alloc_reader) ReadAccountData(address common.Address) (*accounts.Account, error) {
+	account, ok := r.alloc[address]
+	if !ok {
+		return nil, nil
+	}
+
+	acc := accounts.NewAccount()
+	acc.Initialised = true
+	acc.Nonce = account.Nonce
+	if account.Balance != nil {
+		acc.Balance.SetFromBig(account.Balance)
+	}
+	if len(account.Code) > 0 {
+		acc.CodeHash = crypto.Keccak256Hash(account.Code)
+		acc.Incarnation = 1
+	}
+	return &acc, nil
+}
+
+func (r *alloc_reader) ReadAccountStorage(address common.Address, incarnation uint64, key *common.Hash) ([]byte, error) {
+	value, ok := r.alloc[address].Storage[*key]
+	if !ok {
+		return nil, nil
+	}
+	return new(uint256.Int).SetBytes(value.Bytes()).Bytes(), nil
+}
+
+func (r *alloc_reader) ReadAccountCode(address common.Address, incarnation uint64, codeHash common.Hash) ([]byte, error) {
+	return r.alloc[address].Code, nil
+}
+
+func (r *alloc_reader) ReadAccountCodeSize(address common.Address, incarnation uint64, codeHash common.Hash) (int, error) {
+	return len(r.alloc[address].Code), nil
+}
+
+func (r *alloc_reader) ReadAccountIncarnation(address common.Address) (uint64, error) {
+	if len(r.alloc[address].Code) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"time"
-
-	// "time"
+	"os"
+	"sync"
 
+	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+	"github.com/ledgerwatch/erigon/common"
 	"github.com/ledgerwatch/erigon/core/rawdb"
 	"github.com/ledgerwatch/erigon/core/state"
 	"github.com/ledgerwatch/erigon/core/types"
@@ -17,156 +18,314 @@ import (
 	log_ "github.com/ledgerwatch/log/v3"
 )
 
-// used for dot files
-var (
-	BLOCK_NUMBER = -1
-	TXN_IDX      = -1
-)
-
-func analize(block *types.Block, ibs *state.IntraBlockState, chainCfg *params.ChainConfig) []*evm {
-
-	blockN := block.NumberU64()
-	BLOCK_NUMBER = int(blockN)
+// get_hash returns canonical block hashes for BLOCKHASH
+func analize(ctx context.Context, block *types.Block, ibs *state.IntraBlockState, chainCfg *params.ChainConfig, get_hash func(uint64) common.Hash) []*evm {
 
 	var result []*evm
+	summaries := new_summary_cache()
 
-	// fmt.Printf("---------------- BLOCK %d ----------------\n", blockN)
+	// fmt.Printf("---------------- BLOCK %d ----------------\n", block.NumberU64())
 
 	for i, txn := range block.Transactions() {
 		// fmt.Printf("-- TXNID %d --\n", i)
-		TXN_IDX = i
+		result = append(result, analize_tx(ctx, block, ibs, chainCfg, get_hash, summaries, i, txn))
+	}
+	return result
+}
 
-		chainCfg.ChainID = txn.GetChainID().ToBig()
-		signer := types.MakeSigner(chainCfg, blockN)
-		msg, err := txn.AsMessage(*signer, block.BaseFee())
+// summaries are shared by the transactions of the block, nil disables them
+func analize_tx(ctx context.Context, block *types.Block, ibs *state.IntraBlockState, chainCfg *params.ChainConfig, get_hash func(uint64) common.Hash, summaries *summary_cache, i int, txn types.Transaction) *evm {
+	// blocks and transactions may be analysed concurrently, the chain id
+	// is set on a copy
+	cfg := *chainCfg
+	cfg.ChainID = txn.GetChainID().ToBig()
+	signer := types.MakeSigner(&cfg, block.NumberU64())
+	msg, err := txn.AsMessage(*signer, block.BaseFee())
 
-		if err != nil {
-			log.Fatal(err)
-		}
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		contractCreation := msg.To() == nil
-		sender := AccountRef(msg.From())
-		evm := new_evm(block, ibs, *chainCfg, msg)
-		input := txn.GetData() // in case of contract creation it's a code
-		value := txn.GetValue()
+	contractCreation := msg.To() == nil
+	sender := AccountRef(msg.From())
+	evm := new_evm(ctx, block, ibs, cfg, msg)
+	evm.get_hash = get_hash
+	evm.summaries = summaries
+	evm.report.txnIDX = i
+	input := txn.GetData() // in case of contract creation it's a code
+	value := txn.GetValue()
+
+	if contractCreation {
+		// create contract
+		evm.create(sender, input, value)
+	} else {
+		// message call
+		evm.call(sender, *msg.To(), input, value)
+	}
 
-		if contractCreation {
-			// create contract
-			evm.create(sender, input, value)
-		} else {
-			// message call
-			evm.call(sender, *msg.To(), input, value)
-		}
+	if evm.explorer == EXPLORER_GRAPH {
+		write_cfgs(evm, CFG_FORMATS)
+	}
+	return evm
+}
 
-		TXN_IDX = -1
-		result = append(result, evm)
+// analizes the transactions of the block against the state reader reads,
+// on workers goroutines when workers > 1. Each transaction runs on its own
+// intra block state over a shared cache, results are in transaction order.
+// The error is the first one the reader returned.
+func analize_state(ctx context.Context, block *types.Block, reader state.StateReader, chainCfg *params.ChainConfig, get_hash func(uint64) common.Hash, workers int) ([]*evm, error) {
+	txs := block.Transactions()
+	if workers <= 1 || len(txs) < 2 {
+		ibs := state.New(reader)
+		results := analize(ctx, block, ibs, chainCfg, get_hash)
+		return results, ibs.Error()
 	}
-	BLOCK_NUMBER = -1
-	return result
+
+	cache := new_cached_reader(reader)
+	get_hash = cache.locked(get_hash)
+	summaries := new_summary_cache()
+
+	results := make([]*evm, len(txs))
+	errs := make([]error, len(txs))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				ibs := state.New(cache)
+				results[i] = analize_tx(ctx, block, ibs, chainCfg, get_hash, summaries, i, txs[i])
+				errs[i] = ibs.Error()
+			}
+		}()
+	}
+	for i := range txs {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
 }
 
 func handle_results(results []*evm, block_number int) bool {
 
-	all := true // did all analysis finished successfully?
-	for _, _evm := range results {
+	// crossing points are collected even when the verdict does not need
+	// them, reports and explain show them
+	new_access_index(results).set_crosses(results)
 
+	for _, _evm := range results {
 		// if at least one of them failed, there is no way
 		// we can confirm that transactions either depend on each other or not
 		if !_evm.result {
-			all = false
-			break
+			return false
 		}
 	}
 
-	if all { // if all transactions finished successfully
-		// check read/write sets
-		all_read := true
-		for _, _evm := range results {
-			if len(_evm.rw_set.write_set) > 0 {
-				all_read = false
-				break
-			}
+	for _, _evm := range results {
+		// there is at least one crossing point
+		// if this was executed independently
+		// there is a risk of messing the state
+		if len(_evm.rw_set.cross_set) > 0 {
+			return false
 		}
+	}
 
-		if all_read { // if all they do is read - they are independent
-			return true
-		}
+	// no crossing points (or all they do is read),
+	// so it can be executed independently
+	return true
+}
 
-		length := len(results)
+// analysis of one block of a range
+type block_job struct {
+	number  int
+	stored  bool // already in the results store, skipped
+	missing bool // not in chaindata
+	block   *types.Block
+	result  bool
+	results []*evm
+}
 
-		for i := 0; i < length; i++ {
-			for j := 0; j < length; j++ {
+// analizes blocks from..to (to < 0: until the last block of chaindata) on
+// -workers goroutines, each with its own read transaction; results are
+// printed in block order. With a results store every block is saved once
+// analysed and blocks already in the store are skipped, so an interrupted
+// scan resumes where it stopped.
+func analize_blocks(ctx context.Context, from, to int) {
+	_log := log_.New()
 
-				if i == j {
-					continue
-				}
+	db := mdbx.NewMDBX(_log).Path(*CHAINDATA_PATH).MustOpen()
+	defer db.Close()
 
-				results[i].rw_set.add_cross(j, &results[j].rw_set.write_set)
+	var store kv.RwDB
+	if *STORE != "" {
+		var err error
+		if store, err = open_store(*STORE); err != nil {
+			log.Fatal("Error opening results store: ", err)
+		}
+		defer store.Close()
+	}
 
-			}
+	new_worker := func() (func(int) *block_job, func()) {
+		tx, err := db.BeginRo(context.Background())
+		if err != nil {
+			log.Fatal(err)
 		}
+		chainCfg, _, _ := tests.GetChainConfig("London")
+		return func(i int) *block_job {
+			return analize_job(ctx, tx, store, chainCfg, i)
+		}, tx.Rollback
+	}
 
-		for _, _evm := range results {
-			// there is at least one crossing point
-			// if this was executed independently
-			// there is a risk of messing the state
-			if len(_evm.rw_set.cross_set) > 0 {
-				return false
+	skipped, last := 0, from-1
+	in_order(ctx, from, to, *WORKERS, new_worker, func(job *block_job) bool {
+		last = job.number
+		if job.stored {
+			skipped++
+			return true
+		}
+		if skipped > 0 {
+			fmt.Fprintf(os.Stderr, "%d blocks already stored, resuming at block #%d\n", skipped, job.number)
+			skipped = 0
+		}
+		if job.missing {
+			if to >= 0 {
+				log.Fatalf("Block %d not found\n", job.number)
 			}
+			fmt.Fprintf(os.Stderr, "Block %d not found, stopping\n", job.number)
+			return false
 		}
-
-		// no crossing points, so it can be executed independently
+		if store != nil {
+			if err := store_put(store, new_block_output(job.block, job.result, job.results)); err != nil {
+				log.Fatalf("Error storing block %d: %s\n", job.number, err)
+			}
+		}
+		print_results(job.block, job.result, job.results)
 		return true
+	})
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "%d blocks already stored\n", skipped)
 	}
-	return false
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "Interrupted, blocks from #%d on are not analysed\n", last+1)
+	}
+	s := code_analyses.get_stats()
+	fmt.Fprintf(os.Stderr, "code cache: %d hits, %d misses, %d evictions, %d entries\n", s.Hits, s.Misses, s.Evictions, s.Entries)
 }
 
-// goes over each block from start untill encounters an error.
-// analizes this block
-func analize_blocks(start int) {
-	_log := log_.New()
-
-	db := mdbx.NewMDBX(_log).Path(*CHAINDATA_PATH).MustOpen()
-	defer db.Close()
-
-	tx, err := db.BeginRo(context.Background())
-	if err != nil {
-		log.Fatal(err)
+func analize_job(ctx context.Context, tx kv.Tx, store kv.RoDB, chainCfg *params.ChainConfig, i int) *block_job {
+	job := &block_job{number: i}
+	var err error
+	if store != nil {
+		if job.stored, err = store_has(store, uint64(i)); err != nil {
+			log.Fatal(err)
+		}
+		if job.stored {
+			return job
+		}
+	}
+	if job.block, err = rawdb.ReadBlockByNumber(tx, uint64(i)); err != nil {
+		log.Fatalf("Error reading block %d: %s\n", i, err)
+	}
+	if job.missing = job.block == nil; job.missing {
+		return job
 	}
-	defer tx.Rollback()
 
-	chainCfg, _, _ := tests.GetChainConfig("London")
+	reader := state.NewPlainState(tx, job.block.NumberU64())
 
-	DOT_FLAG = -1
+	if job.results, err = analize_state(ctx, job.block, reader, chainCfg, canonical_hash(tx), *TX_WORKERS); err != nil {
+		log.Fatalf("Error reading the state of block %d: %s\n", i, err)
+	}
+	job.result = handle_results(job.results, i)
+	return job
+}
 
-	for i := start; ; i++ {
+// runs work for the blocks from..to (to < 0: no end) on workers goroutines
+// and calls emit with the jobs in block order until it returns false or ctx
+// is done; jobs finished after ctx is done are not emitted.
+// new_worker is called on the goroutine of each worker and returns its
+// work function and what to release when it is done.
+func in_order(ctx context.Context, from, to, workers int, new_worker func() (func(int) *block_job, func()), emit func(*block_job) bool) {
+	if workers < 1 {
+		workers = 1
+	}
 
-		block, err := rawdb.ReadBlockByNumber(tx, uint64(i))
-		if err != nil {
-			log.Fatalf("Error reading block %d: %s\n", i, err)
+	// at most window blocks are analysed or wait for an earlier one
+	window := make(chan struct{}, 4*workers)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		// workers release what they hold before the caller closes it
+		close(done)
+		wg.Wait()
+	}()
+
+	numbers := make(chan int)
+	go func() {
+		defer close(numbers)
+		for i := from; to < 0 || i <= to; i++ {
+			select {
+			case window <- struct{}{}:
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case numbers <- i:
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
 		}
-
-		reader := state.NewPlainState(tx, block.NumberU64())
-		dbstate := state.New(reader)
-
-		results := analize(block, dbstate, chainCfg)
-		result := handle_results(results, i)
-		fmt.Printf("\nIndependent execution for block #%d: %t\n", i, result)
-		if result && len(results) > 1 {
-			fmt.Println("Number of transactions: ", len(results))
-			for i, _evm := range results {
-				_evm.rw_set.print(i)
+	}()
+
+	jobs := make(chan *block_job)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work, release := new_worker()
+			defer release()
+			for i := range numbers {
+				select {
+				case jobs <- work(i):
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(jobs)
+	}()
+
+	next := from
+	pending := make(map[int]*block_job)
+	for job := range jobs {
+		pending[job.number] = job
+		for job, ok := pending[next]; ok; job, ok = pending[next] {
+			delete(pending, next)
+			next++
+			<-window
+			if ctx.Err() != nil || !emit(job) {
+				return
 			}
-
-			time.Sleep(time.Second * 2)
 		}
-		fmt.Println()
 	}
 }
 
 // reads single block at block_number.
 // analizes this block
-func analize_block(block_number int) bool {
+func analize_block(ctx context.Context, block_number int) bool {
 	_log := log_.New()
 	db := mdbx.NewMDBX(_log).Path(*CHAINDATA_PATH).MustOpen()
 
@@ -186,15 +345,109 @@ func analize_block(block_number int) bool {
 	}
 
 	reader := state.NewPlainState(tx, block.NumberU64())
-	dbstate := state.New(reader)
 
-	results := analize(block, dbstate, chainCfg)
+	results, err := analize_state(ctx, block, reader, chainCfg, canonical_hash(tx), *TX_WORKERS)
+	if err != nil {
+		log.Fatalln("Error reading state: ", err)
+	}
 	result := handle_results(results, block_number)
-	fmt.Printf("\nIndependent execution for block #%d: %t\n", block_number, result)
-	fmt.Println("Number of transactions: ", len(results))
-	for i, _evm := range results {
-		_evm.rw_set.print(i)
+	print_results(block, result, results)
+	return result
+}
+
+func print_results(block *types.Block, result bool, results []*evm) {
+	for _, _evm := range results {
+		if !_evm.result {
+			analysis_failures++
+		}
+	}
+	if EXPLAIN {
+		check_txs(block.NumberU64(), results, TXS)
+		if err := write_explain(os.Stdout, *FORMAT, block.NumberU64(), results, TXS[0], TXS[1]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	fmt.Println()
+	if err := write_results(os.Stdout, *FORMAT, block, result, results); err != nil {
+		log.Fatal(err)
+	}
+	if explorer_mode() == EXPLORER_GRAPH {
+		make_txs_dot_file(block, results)
+	}
+	if *WHY != "" {
+		print_provenance(block.NumberU64(), results, TXS)
+	}
+	if *COVERAGE {
+		for _, _evm := range results {
+			print_coverage(_evm)
+		}
+	}
+}
+
+func print_coverage(_evm *evm) {
+	if err := write_coverage(os.Stdout, *FORMAT, _evm); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// prints where the accesses of the -why address come from
+func print_provenance(block_number uint64, results []*evm, txs []int) {
+	addr, err := parse_address(*WHY)
+	if err != nil {
+		log.Fatal("Invalid address: ", err)
+	}
+	check_txs(block_number, results, txs)
+	if err := write_provenance(os.Stdout, *FORMAT, block_number, results, txs, addr); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func check_txs(block_number uint64, results []*evm, txs []int) {
+	for _, tx := range txs {
+		if tx >= len(results) {
+			log.Fatalf("Block #%d has %d transactions, no transaction %d\n", block_number, len(results), tx)
+		}
+	}
+}
+
+// canonical block hashes from chaindata
+func canonical_hash(tx kv.Tx) func(uint64) common.Hash {
+	return func(n uint64) common.Hash {
+		hash, err := rawdb.ReadCanonicalHash(tx, n)
+		if err != nil {
+			log.Fatalf("Error reading canonical hash %d: %s\n", n, err)
+		}
+		return hash
+	}
+}
+
+// analizes a block given as JSON (eth_getBlockByNumber with transactions)
+// on top of a prestate JSON file instead of chaindata
+func analize_block_json(ctx context.Context, block_path, prestate_path string) bool {
+	block, err := read_block_json(block_path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pre := make(prestate)
+	if prestate_path != "" {
+		if pre, err = read_prestate(prestate_path); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	chainCfg, _, _ := tests.GetChainConfig("London")
+
+	// the parent is the only block hash a block JSON knows about
+	get_hash := func(n uint64) common.Hash {
+		if n+1 == block.NumberU64() {
+			return block.ParentHash()
+		}
+		return common.Hash{}
+	}
+
+	results, _ := analize_state(ctx, block, new_alloc_reader(pre.alloc()), chainCfg, get_hash, *TX_WORKERS)
+	result := handle_results(results, int(block.NumberU64()))
+	print_results(block, result, results)
 	return result
 }
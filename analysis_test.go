@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInOrder(t *testing.T) {
+	var workers, released int32
+	new_worker := func() (func(int) *block_job, func()) {
+		atomic.AddInt32(&workers, 1)
+		return func(i int) *block_job {
+			time.Sleep(time.Duration(rand.Intn(300)) * time.Microsecond)
+			return &block_job{number: i, missing: i == 75}
+		}, func() { atomic.AddInt32(&released, 1) }
+	}
+
+	var got []int
+	in_order(context.Background(), 10, 60, 8, new_worker, func(job *block_job) bool {
+		got = append(got, job.number)
+		return true
+	})
+	if len(got) != 51 {
+		t.Fatalf("%d blocks, want 51", len(got))
+	}
+	for i, n := range got {
+		if n != 10+i {
+			t.Fatalf("blocks out of order: %v", got)
+		}
+	}
+	if workers != 8 || released != 8 {
+		t.Errorf("%d workers started, %d released, want 8", workers, released)
+	}
+
+	// no end, stopped by emit
+	got = nil
+	in_order(context.Background(), 50, -1, 4, new_worker, func(job *block_job) bool {
+		if job.missing {
+			return false
+		}
+		got = append(got, job.number)
+		return true
+	})
+	if len(got) != 25 || got[24] != 74 {
+		t.Errorf("blocks %v, want 50..74", got)
+	}
+	if released != workers {
+		t.Errorf("%d workers started, %d released when in_order returned", workers, released)
+	}
+
+	// no end, stopped by ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	got = nil
+	in_order(ctx, 0, -1, 4, new_worker, func(job *block_job) bool {
+		got = append(got, job.number)
+		if job.number == 20 {
+			cancel()
+		}
+		return true
+	})
+	if len(got) != 21 || got[20] != 20 {
+		t.Errorf("blocks %v, want 0..20", got)
+	}
+	if released != workers {
+		t.Errorf("%d workers started, %d released when in_order was cancelled", workers, released)
+	}
+}
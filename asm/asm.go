@@ -0,0 +1,285 @@
+// Package asm is a small EVM assembler/disassembler used to write bytecode
+// fixtures and to print code in the dot files.
+//
+// Syntax, whitespace separated, comments start with ';' or "//":
+//
+//	PUSH1 0x01          explicit size, value is left padded
+//	PUSH 300            smallest PUSHn that fits the value
+//	PUSH @loop          offset of a label
+//	PUSH #table         length of a data label
+//	loop:               label, emits a JUMPDEST
+//	JUMP @loop          shorthand for PUSH @loop JUMP (same for JUMPI)
+//	.data 0xdeadbeef    raw bytes
+//	.data table 0x0102  labelled raw bytes, no JUMPDEST
+package asm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+type item struct {
+	line  int
+	op    byte
+	size  int    // PUSH immediate size, -1 if it has to be derived
+	value []byte // PUSH immediate / raw data
+	ref   string // label reference ("@name" or "#name")
+	data  bool
+}
+
+type label struct {
+	item int // index of the labelled item
+	data bool
+}
+
+// Assemble translates src into bytecode.
+func Assemble(src string) ([]byte, error) {
+	items, labels, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// label offsets depend on PUSH sizes and vice versa, sizes only grow so
+	// this converges
+	offsets := make([]uint64, len(items)+1)
+	for {
+		for i, it := range items {
+			offsets[i+1] = offsets[i] + it.len()
+		}
+
+		changed := false
+		for i := range items {
+			it := &items[i]
+			if it.ref == "" {
+				continue
+			}
+			v, err := resolve(it, items, labels, offsets)
+			if err != nil {
+				return nil, err
+			}
+			it.value = v
+			if it.size < 0 && len(v) > it.pushlen() {
+				it.op = 0x5f + byte(len(v))
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	code := make([]byte, 0, offsets[len(items)])
+	for _, it := range items {
+		if it.data {
+			code = append(code, it.value...)
+			continue
+		}
+		code = append(code, it.op)
+		if n := PushSize(it.op); n > 0 {
+			if len(it.value) > n {
+				return nil, fmt.Errorf("line %d: value 0x%x does not fit in %s", it.line, it.value, names[it.op])
+			}
+			code = append(code, make([]byte, n-len(it.value))...)
+			code = append(code, it.value...)
+		}
+	}
+	return code, nil
+}
+
+// MustAssemble is like Assemble but panics on error, for fixtures.
+func MustAssemble(src string) []byte {
+	code, err := Assemble(src)
+	if err != nil {
+		panic(err)
+	}
+	return code
+}
+
+func (it *item) pushlen() int {
+	return PushSize(it.op)
+}
+
+func (it *item) len() uint64 {
+	if it.data {
+		return uint64(len(it.value))
+	}
+	return 1 + uint64(it.pushlen())
+}
+
+func resolve(it *item, items []item, labels map[string]label, offsets []uint64) ([]byte, error) {
+	name := it.ref[1:]
+	l, ok := labels[name]
+	if !ok {
+		return nil, fmt.Errorf("line %d: undefined label %q", it.line, name)
+	}
+
+	var v uint64
+	if it.ref[0] == '@' {
+		v = offsets[l.item]
+	} else {
+		if !l.data {
+			return nil, fmt.Errorf("line %d: %q is not a data label", it.line, name)
+		}
+		v = items[l.item].len()
+	}
+	return new(big.Int).SetUint64(v).Bytes(), nil
+}
+
+func parse(src string) ([]item, map[string]label, error) {
+	var items []item
+	labels := make(map[string]label)
+
+	define := func(line int, name string, data bool) error {
+		if !valid_label(name) {
+			return fmt.Errorf("line %d: invalid label %q", line, name)
+		}
+		if _, ok := labels[name]; ok {
+			return fmt.Errorf("line %d: label %q redefined", line, name)
+		}
+		labels[name] = label{item: len(items), data: data}
+		return nil
+	}
+
+	for n, line := range strings.Split(src, "\n") {
+		n += 1
+		if i := strings.Index(line, ";"); i > -1 {
+			line = line[:i]
+		}
+		if i := strings.Index(line, "//"); i > -1 {
+			line = line[:i]
+		}
+
+		tokens := strings.Fields(line)
+		for i := 0; i < len(tokens); i++ {
+			tok := tokens[i]
+			next := func() (string, error) {
+				if i+1 >= len(tokens) {
+					return "", fmt.Errorf("line %d: %s expects an argument", n, tok)
+				}
+				i++
+				return tokens[i], nil
+			}
+
+			switch {
+			case strings.HasSuffix(tok, ":"):
+				if err := define(n, strings.TrimSuffix(tok, ":"), false); err != nil {
+					return nil, nil, err
+				}
+				items = append(items, item{line: n, op: 0x5b})
+
+			case tok == ".data":
+				arg, err := next()
+				if err != nil {
+					return nil, nil, err
+				}
+				if !strings.HasPrefix(arg, "0x") {
+					if err := define(n, arg, true); err != nil {
+						return nil, nil, err
+					}
+					if arg, err = next(); err != nil {
+						return nil, nil, err
+					}
+				}
+				b, err := parse_hex(arg)
+				if err != nil {
+					return nil, nil, fmt.Errorf("line %d: %v", n, err)
+				}
+				items = append(items, item{line: n, value: b, data: true})
+
+			default:
+				name := strings.ToUpper(tok)
+				if name == "PUSH" {
+					arg, err := next()
+					if err != nil {
+						return nil, nil, err
+					}
+					it, err := push_item(n, -1, arg)
+					if err != nil {
+						return nil, nil, err
+					}
+					items = append(items, it)
+					continue
+				}
+
+				op, ok := opcodes[name]
+				if !ok {
+					return nil, nil, fmt.Errorf("line %d: unknown instruction %q", n, tok)
+				}
+				if size := PushSize(op); size > 0 {
+					arg, err := next()
+					if err != nil {
+						return nil, nil, err
+					}
+					it, err := push_item(n, size, arg)
+					if err != nil {
+						return nil, nil, err
+					}
+					items = append(items, it)
+					continue
+				}
+
+				// JUMP @label, JUMPI @label
+				if (op == 0x56 || op == 0x57) && i+1 < len(tokens) && strings.HasPrefix(tokens[i+1], "@") {
+					it, _ := push_item(n, -1, tokens[i+1])
+					items = append(items, it)
+					i++
+				}
+				items = append(items, item{line: n, op: op})
+			}
+		}
+	}
+
+	return items, labels, nil
+}
+
+// push_item builds a PUSH with size bytes (-1 for the smallest that fits).
+func push_item(line int, size int, arg string) (item, error) {
+	it := item{line: line, op: 0x60, size: size}
+	if size > 0 {
+		it.op = 0x5f + byte(size)
+	}
+
+	if strings.HasPrefix(arg, "@") || strings.HasPrefix(arg, "#") {
+		it.ref = arg
+		return it, nil
+	}
+
+	v, ok := new(big.Int).SetString(arg, 0)
+	if !ok || v.Sign() < 0 {
+		return it, fmt.Errorf("line %d: invalid push value %q", line, arg)
+	}
+	if v.BitLen() > 256 {
+		return it, fmt.Errorf("line %d: push value %q exceeds 32 bytes", line, arg)
+	}
+	it.value = v.Bytes()
+
+	if size < 0 && len(it.value) > 1 {
+		it.op = 0x5f + byte(len(it.value))
+	}
+	if size > 0 && len(it.value) > size {
+		return it, fmt.Errorf("line %d: value %s does not fit in PUSH%d", line, arg, size)
+	}
+	return it, nil
+}
+
+func parse_hex(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid data %q: %v", s, err)
+	}
+	return b, nil
+}
+
+func valid_label(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
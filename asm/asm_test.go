@@ -0,0 +1,82 @@
+package asm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"push sizing", "PUSH 0 PUSH 255 PUSH 256 PUSH 0x010203", "6000" + "60ff" + "610100" + "62010203"},
+		{"explicit size", "PUSH4 0x01 push2 7", "6300000001" + "610007"},
+		{"comments", "ADD ; add\nMUL // mul\n", "0102"},
+		{"label", "PUSH @end JUMP\nSTOP\nend:\nSTOP", "600456" + "00" + "5b00"},
+		{"jump shorthand", "loop: PUSH1 1 JUMPI @loop", "5b" + "6001" + "6000" + "57"},
+		{"data", "PUSH #tbl PUSH @tbl PUSH1 0 CODECOPY STOP\n.data tbl 0xdeadbeef\n.data 0xff", "6004" + "6008" + "6000" + "39" + "00" + "deadbeef" + "ff"},
+		{"alias", "KECCAK256", "20"},
+	}
+
+	for _, c := range cases {
+		code, err := Assemble(c.src)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := hex.EncodeToString(code); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestAssembleLabelGrowth(t *testing.T) {
+	// the label moves past 255 so every reference to it needs a PUSH2
+	src := "PUSH @far JUMP PUSH @far JUMP\n" + strings.Repeat("JUMPDEST\n", 300) + "far: STOP"
+	code, err := Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x61, 0x01, 0x34, 0x56, 0x61, 0x01, 0x34, 0x56}
+	if !bytes.HasPrefix(code, want) || code[0x134] != 0x5b {
+		t.Fatalf("unexpected code %x", code[:8])
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	for _, src := range []string{
+		"FOO",
+		"PUSH1 256",
+		"PUSH1",
+		"PUSH @nowhere",
+		"a: a:",
+		"a: PUSH #a",
+		".data 0xzz",
+		"PUSH 0x1" + strings.Repeat("00", 32),
+	} {
+		if _, err := Assemble(src); err == nil {
+			t.Errorf("%q: expected error", src)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		"6080604052348015600f57600080fd5b50",
+		"0c0d5b60", // undefined opcodes and a truncated push
+		"7f" + strings.Repeat("11", 32) + "a4fe",
+	} {
+		code, _ := hex.DecodeString(s)
+		out, err := Assemble(Format(code))
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", s, err, Format(code))
+		}
+		if !bytes.Equal(out, code) {
+			t.Errorf("round trip of %s gave %x", s, out)
+		}
+	}
+}
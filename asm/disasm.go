@@ -0,0 +1,91 @@
+package asm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Instruction is a single decoded instruction.
+// Undefined opcodes and truncated PUSH data are decoded as raw data (Op is
+// still set, Data holds the bytes as they appear in the code).
+type Instruction struct {
+	PC   uint64
+	Op   byte
+	Arg  []byte // PUSH immediate
+	Data []byte // raw bytes, set when the instruction can not be decoded
+}
+
+// Size returns how many bytes of code the instruction takes.
+func (ins Instruction) Size() uint64 {
+	if ins.Data != nil {
+		return uint64(len(ins.Data))
+	}
+	return 1 + uint64(len(ins.Arg))
+}
+
+// Name returns the mnemonic of the instruction, ".data" for raw bytes.
+func (ins Instruction) Name() string {
+	if ins.Data != nil {
+		return ".data"
+	}
+	return names[ins.Op]
+}
+
+// String renders the instruction in the syntax accepted by Assemble.
+func (ins Instruction) String() string {
+	if ins.Data != nil {
+		return ".data 0x" + hex.EncodeToString(ins.Data)
+	}
+	if PushSize(ins.Op) > 0 {
+		return fmt.Sprintf("%s 0x%s", names[ins.Op], hex.EncodeToString(ins.Arg))
+	}
+	return names[ins.Op]
+}
+
+// Next decodes the instruction at pc. code is not required to end at an
+// instruction boundary.
+func Next(code []byte, pc uint64) Instruction {
+	op := code[pc]
+	ins := Instruction{PC: pc, Op: op}
+
+	if names[op] == "" {
+		ins.Data = code[pc : pc+1]
+		return ins
+	}
+
+	if n := uint64(PushSize(op)); n > 0 {
+		end := pc + 1 + n
+		if end > uint64(len(code)) { // truncated push at the end of the code
+			ins.Data = code[pc:]
+			return ins
+		}
+		ins.Arg = code[pc+1 : end]
+	}
+	return ins
+}
+
+// Disassemble decodes code[start:stop]; stop is clamped to len(code).
+func Disassemble(code []byte, start, stop uint64) []Instruction {
+	if stop > uint64(len(code)) {
+		stop = uint64(len(code))
+	}
+
+	var out []Instruction
+	for pc := start; pc < stop; {
+		ins := Next(code, pc)
+		out = append(out, ins)
+		pc += ins.Size()
+	}
+	return out
+}
+
+// Format returns the listing of code, one instruction per line with its pc
+// as a comment. The output assembles back to the same bytes.
+func Format(code []byte) string {
+	var b strings.Builder
+	for _, ins := range Disassemble(code, 0, uint64(len(code))) {
+		fmt.Fprintf(&b, "%-32s ; %d\n", ins.String(), ins.PC)
+	}
+	return b.String()
+}
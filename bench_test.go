@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/racytech/abs_evm/asm"
+)
+
+// ERC-20 transfer and balanceOf; balances are a mapping at slot 0. The
+// tokens the pair calls have transfer only, a call with two possible
+// return values fails the analysis.
+func erc20_asm(balance_of bool) string {
+	src := `
+		PUSH1 0 CALLDATALOAD PUSH1 0xe0 SHR
+		DUP1 PUSH4 0xa9059cbb EQ JUMPI @transfer
+		DUP1 PUSH4 0x70a08231 EQ JUMPI @balance_of
+		fail:
+		PUSH1 0 DUP1 REVERT
+
+		transfer:
+		CALLER PUSH1 0 MSTORE PUSH1 0 PUSH1 32 MSTORE
+		PUSH1 64 PUSH1 0 SHA3                   ; slot_from
+		DUP1 SLOAD PUSH1 36 CALLDATALOAD         ; slot_from bal amount
+		DUP1 DUP3 LT JUMPI @fail
+		DUP1 SWAP2 SUB DUP3 SSTORE SWAP1 POP     ; amount
+		PUSH1 4 CALLDATALOAD PUSH1 0 MSTORE
+		PUSH1 64 PUSH1 0 SHA3                   ; amount slot_to
+		DUP1 SLOAD DUP3 ADD SWAP1 SSTORE         ; amount
+		PUSH1 0 MSTORE
+		PUSH1 4 CALLDATALOAD CALLER
+		PUSH 0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef
+		PUSH1 32 PUSH1 0 LOG3
+		PUSH1 1 PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+	`
+	if balance_of {
+		src += `
+		balance_of:
+		PUSH1 4 CALLDATALOAD PUSH1 0 MSTORE PUSH1 0 PUSH1 32 MSTORE
+		PUSH1 64 PUSH1 0 SHA3 SLOAD PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+		`
+	} else {
+		src = strings.Replace(src, "DUP1 PUSH4 0x70a08231 EQ JUMPI @balance_of", "", 1)
+	}
+	return src
+}
+
+// Uniswap V2 style swap(amount0Out, amount1Out, to): transfers the
+// tokens at slots 6 and 7 out and updates the reserves at slots 8 and 9
+var pair_asm = `
+	PUSH1 0 CALLDATALOAD PUSH1 0xe0 SHR PUSH4 0x022c0d9f EQ JUMPI @swap
+	PUSH1 0 DUP1 REVERT
+
+	swap:
+	PUSH1 8 SLOAD PUSH1 9 SLOAD                              ; r0 r1
+	PUSH1 4 CALLDATALOAD ISZERO JUMPI @skip0
+	PUSH4 0xa9059cbb PUSH1 0xe0 SHL PUSH1 0 MSTORE
+	PUSH1 68 CALLDATALOAD PUSH1 4 MSTORE PUSH1 4 CALLDATALOAD PUSH1 36 MSTORE
+	PUSH1 32 PUSH1 0 PUSH1 68 PUSH1 0 PUSH1 0 PUSH1 6 SLOAD GAS CALL POP
+	skip0:
+	PUSH1 36 CALLDATALOAD ISZERO JUMPI @skip1
+	PUSH4 0xa9059cbb PUSH1 0xe0 SHL PUSH1 0 MSTORE
+	PUSH1 68 CALLDATALOAD PUSH1 4 MSTORE PUSH1 36 CALLDATALOAD PUSH1 36 MSTORE
+	PUSH1 32 PUSH1 0 PUSH1 68 PUSH1 0 PUSH1 0 PUSH1 7 SLOAD GAS CALL POP
+	skip1:
+	PUSH1 36 CALLDATALOAD SWAP1 SUB PUSH1 9 SSTORE
+	PUSH1 4 CALLDATALOAD SWAP1 SUB PUSH1 8 SSTORE
+	PUSH 0x1c411e9a96e071241c2f21f7726b17ae89e3cab4c78be50e062b03a9fffbbad1
+	PUSH1 0 PUSH1 0 LOG1
+	STOP
+`
+
+// n functions selected linearly, each returns a storage slot
+func dispatcher_asm(n int) string {
+	var b strings.Builder
+	b.WriteString("PUSH1 0 CALLDATALOAD PUSH1 0xe0 SHR\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "DUP1 PUSH4 %#x EQ JUMPI @f%d\n", 0x10000000+i, i)
+	}
+	b.WriteString("PUSH1 0 DUP1 REVERT\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "f%d: PUSH %d SLOAD PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN\n", i, i)
+	}
+	return b.String()
+}
+
+// counts down from 500, reading a slot every cycle
+var loop_asm = `
+	PUSH2 500
+	loop:
+	PUSH1 0 SLOAD POP
+	PUSH1 1 SWAP1 SUB
+	DUP1 JUMPI @loop
+	POP STOP
+`
+
+func calldata(selector string, words ...uint64) []byte {
+	data := common.FromHex(selector)
+	for _, w := range words {
+		word := uint256.NewInt(w).Bytes32()
+		data = append(data, word[:]...)
+	}
+	return data
+}
+
+var (
+	bench_token = common.HexToAddress("0x70")
+	bench_pair  = common.HexToAddress("0x0a")
+	bench_to    = common.HexToAddress("0x0b")
+)
+
+func bench_runs() map[string]code_run {
+	token0, token1 := common.HexToAddress("0x71"), common.HexToAddress("0x72")
+	transfer_only := asm.MustAssemble(erc20_asm(false))
+	return map[string]code_run{
+		"erc20_transfer": {
+			code:    asm.MustAssemble(erc20_asm(true)),
+			address: bench_token,
+			caller:  bench_to,
+			input:   calldata("0xa9059cbb", 0x0c, 100),
+			pre:     make(prestate),
+		},
+		"swap": {
+			code:    asm.MustAssemble(pair_asm),
+			address: bench_pair,
+			caller:  bench_to,
+			input:   calldata("0x022c0d9f", 10, 20, 0x0b),
+			pre: prestate{
+				bench_pair: &prestate_account{Storage: map[common.Hash]common.Hash{
+					common.HexToHash("0x06"): token0.Hash(),
+					common.HexToHash("0x07"): token1.Hash(),
+					common.HexToHash("0x08"): common.HexToHash("0x1000"),
+					common.HexToHash("0x09"): common.HexToHash("0x2000"),
+				}},
+				token0: &prestate_account{Code: transfer_only},
+				token1: &prestate_account{Code: transfer_only},
+			},
+		},
+		"dispatcher": {
+			code:    asm.MustAssemble(dispatcher_asm(64)),
+			address: bench_pair,
+			input:   calldata("0x1000003f"),
+			pre:     make(prestate),
+		},
+		"loop": {
+			code:    asm.MustAssemble(loop_asm),
+			address: bench_pair,
+			pre:     make(prestate),
+		},
+	}
+}
+
+func TestBenchCodes(t *testing.T) {
+	for name, r := range bench_runs() {
+		if _evm := run_code(r); !_evm.result {
+			t.Errorf("%s: analysis failed: %s", name, _evm.fail_reason)
+		}
+	}
+}
+
+func bench_run(b *testing.B, name string) {
+	run := bench_runs()[name].prepare()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		run(context.Background())
+	}
+}
+
+// new_tree over a whole transaction
+func BenchmarkTreeERC20Transfer(b *testing.B) { bench_run(b, "erc20_transfer") }
+func BenchmarkTreeSwap(b *testing.B)          { bench_run(b, "swap") }
+func BenchmarkTreeDispatcher(b *testing.B)    { bench_run(b, "dispatcher") }
+func BenchmarkTreeLoop(b *testing.B)          { bench_run(b, "loop") }
+
+func bench_ctx(code []byte) (*evm, *callCtx) {
+	_evm := run_code(code_run{code: []byte{byte(STOP)}, address: bench_pair, pre: make(prestate)})
+	contract := new_contract(AccountRef(bench_to), AccountRef(bench_pair), new(uint256.Int))
+	contract.set_call_code(&bench_pair, common.Hash{}, code)
+	return _evm, &callCtx{memory: NewMemory(), stack: NewStack(), contract: contract}
+}
+
+// interpreter.run over a block of arithmetic and memory instructions
+func BenchmarkInterpreterRun(b *testing.B) {
+	code := asm.MustAssemble(strings.Repeat("PUSH1 3 PUSH1 5 MUL PUSH1 1 ADD PUSH1 0 MSTORE ", 100) + "STOP")
+	size := uint64(len(code))
+	_evm, ctx := bench_ctx(code)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var pc uint64
+		ctx.stack.Reset()
+		_evm.interpreter.run(&pc, ctx, &code, &size)
+	}
+}
+
+// handle_loop, which runs interpreter.lp_run once per cycle
+func BenchmarkHandleLoop(b *testing.B) {
+	code := asm.MustAssemble(loop_asm)
+	size := uint64(len(code))
+	_evm, ctx := bench_ctx(code)
+	start := uint64(3) // the loop JUMPDEST, after PUSH2 500
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx.stack.Reset()
+		ctx.stack.Push(uint256.NewInt(500))
+		if _, ok := handle_loop(_evm, ctx, start, size, &code); !ok {
+			b.Fatal("loop did not finish")
+		}
+	}
+}
+
+// a block of txs transactions, each reading 20 and writing 4 of addrs
+// addresses
+func synthetic_block(txs, addrs int) []*evm {
+	addr := func(n int) common.Address { return common.BytesToAddress([]byte{byte(n >> 16), byte(n >> 8), byte(n)}) }
+	results := make([]*evm, txs)
+	for i := range results {
+		results[i] = &evm{rw_set: new_set_addr(), result: true}
+		for j := 0; j < 20; j++ {
+			results[i].rw_set.add(addr((i*7+j*13)%addrs), READ)
+		}
+		for j := 0; j < 4; j++ {
+			results[i].rw_set.add(addr((i*11+j*31)%addrs), WRITE)
+		}
+	}
+	return results
+}
+
+// a block of txs transactions reading and writing the same address, as
+// all swaps of a batch do with their router
+func hot_block(txs int) []*evm {
+	hot := common.HexToAddress("0x7a")
+	results := make([]*evm, txs)
+	for i := range results {
+		results[i] = &evm{rw_set: new_set_addr(), result: true}
+		results[i].rw_set.add(hot, READ)
+		results[i].rw_set.add(hot, WRITE)
+	}
+	return results
+}
+
+func bench_handle_results(b *testing.B, results []*evm) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handle_results(results, 0)
+	}
+}
+
+func BenchmarkHandleResults(b *testing.B) { bench_handle_results(b, synthetic_block(200, 500)) }
+
+// an L2-sized batch
+func BenchmarkHandleResults5000(b *testing.B) {
+	bench_handle_results(b, synthetic_block(5000, 100000))
+}
+
+// every transaction of an L2-sized batch touches one address
+func BenchmarkHandleResultsHot(b *testing.B) { bench_handle_results(b, hot_block(5000)) }
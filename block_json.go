@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+	"github.com/ledgerwatch/erigon/core/types"
+)
+
+// returns the "result" of a JSON-RPC response, or data itself if it is not
+// a response
+func rpc_result(data []byte) []byte {
+	var resp struct {
+		Jsonrpc string          `json:"jsonrpc"`
+		Result  json.RawMessage `json:"result"`
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) &&
+		json.Unmarshal(data, &resp) == nil && resp.Jsonrpc != "" && resp.Result != nil {
+		return resp.Result
+	}
+	return data
+}
+
+// decodes a block as returned by eth_getBlockByNumber(n, true)
+func decode_block_json(data []byte) (*types.Block, error) {
+	data = rpc_result(data)
+
+	var header types.Header
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+
+	var dec struct {
+		Hash         *common.Hash      `json:"hash"`
+		BaseFee      *hexutil.Big      `json:"baseFeePerGas"`
+		Transactions []json.RawMessage `json:"transactions"`
+	}
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return nil, err
+	}
+	if dec.BaseFee != nil { // not decoded by types.Header
+		header.BaseFee = dec.BaseFee.ToInt()
+	}
+
+	txs := make([]types.Transaction, 0, len(dec.Transactions))
+	for i, raw := range dec.Transactions {
+		if bytes.HasPrefix(raw, []byte(`"`)) {
+			return nil, fmt.Errorf("transaction %d: block has transaction hashes only, full transactions are required", i)
+		}
+		txn, err := types.UnmarshalTransactionFromJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %w", i, err)
+		}
+		txs = append(txs, txn)
+	}
+
+	hash := header.Hash()
+	if dec.Hash != nil {
+		hash = *dec.Hash
+	}
+	return types.NewBlockFromStorage(hash, &header, txs, nil), nil
+}
+
+func read_block_json(path string) (*types.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, err := decode_block_json(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return block, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common/hexutil"
+	"github.com/ledgerwatch/erigon/tests"
+)
+
+// block JSON as served by eth_getBlockByNumber(n, true)
+func block_json(t *testing.T, f *chain_fixture, i int) []byte {
+	t.Helper()
+	block := f.make_blocks(t)[i]
+
+	header, err := json.Marshal(block.Header())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(header, &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["hash"] = block.Hash()
+	fields["baseFeePerGas"] = (*hexutil.Big)(block.BaseFee())
+	fields["transactions"] = block.Transactions()
+
+	data, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": fields})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeBlockJSON(t *testing.T) {
+	f := load_fixture(t, filepath.Join("testdata", "fixtures", "complicated.json"))
+	want := f.make_blocks(t)[0]
+
+	block, err := decode_block_json(block_json(t, f, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Hash() != want.Hash() || block.NumberU64() != want.NumberU64() || block.BaseFee().Cmp(want.BaseFee()) != 0 {
+		t.Fatalf("decoded header differs: %+v", block.Header())
+	}
+	if len(block.Transactions()) != len(want.Transactions()) {
+		t.Fatalf("%d transactions, want %d", len(block.Transactions()), len(want.Transactions()))
+	}
+	for i, txn := range block.Transactions() {
+		if txn.Hash() != want.Transactions()[i].Hash() {
+			t.Errorf("tx %d: hash %x, want %x", i, txn.Hash(), want.Transactions()[i].Hash())
+		}
+	}
+
+	// the decoded block analyses the same way as the original one
+	pre := make(prestate)
+	data, _ := json.Marshal(f.alloc())
+	if err := json.Unmarshal(data, &pre); err != nil {
+		t.Fatal(err)
+	}
+	chainCfg, _, _ := tests.GetChainConfig("London")
+	results := analize(context.Background(), block, new_alloc_state(pre.alloc()), chainCfg, fixture_hashes(nil))
+	if handle_results(results, int(block.NumberU64())) != f.Blocks[0].Expect.Independent {
+		t.Errorf("verdict differs from the fixture")
+	}
+
+	// eth_getBlockByNumber(n, false)
+	var resp struct {
+		Result map[string]interface{} `json:"result"`
+	}
+	if err := json.Unmarshal(block_json(t, f, 0), &resp); err != nil {
+		t.Fatal(err)
+	}
+	resp.Result["transactions"] = []string{want.Transactions()[0].Hash().Hex()}
+	data, _ = json.Marshal(resp.Result)
+	if _, err := decode_block_json(data); err == nil {
+		t.Error("block with transaction hashes only should be rejected")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// the time is checked every budget_check steps
+const budget_check = 256
+
+// what a transaction has spent; the analysis also stops when ctx is done
+type budget struct {
+	ctx      context.Context
+	deadline time.Time // zero if none
+	limits   limits
+
+	steps, paths, memory uint64
+}
+
+func new_budget(ctx context.Context, l limits) *budget {
+	b := &budget{ctx: ctx, limits: l}
+	if l.Time > 0 {
+		b.deadline = time.Now().Add(time.Duration(l.Time))
+	}
+	if d, ok := ctx.Deadline(); ok && (b.deadline.IsZero() || d.Before(b.deadline)) {
+		b.deadline = d
+	}
+	return b
+}
+
+// what is exceeded, "" if nothing
+func (b *budget) step() string {
+	b.steps++
+	if b.limits.Steps > 0 && b.steps > b.limits.Steps {
+		return fmt.Sprintf("%d interpreter steps", b.limits.Steps)
+	}
+	if b.steps%budget_check == 0 {
+		return b.check_time()
+	}
+	return ""
+}
+
+func (b *budget) path() string {
+	b.paths++
+	if b.limits.Paths > 0 && b.paths > b.limits.Paths {
+		return fmt.Sprintf("%d explored paths", b.limits.Paths)
+	}
+	return b.check_time()
+}
+
+func (b *budget) grow(bytes uint64) string {
+	b.memory += bytes
+	if b.limits.Memory > 0 && b.memory > b.limits.Memory {
+		return fmt.Sprintf("%d bytes of memory", b.limits.Memory)
+	}
+	return ""
+}
+
+func (b *budget) check_time() string {
+	if err := b.ctx.Err(); err != nil {
+		return err.Error()
+	}
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return "time limit"
+	}
+	return ""
+}
+
+// the budget of the transaction is exceeded, the analysis is aborted
+func (evm *evm) exceeded(what string) bool {
+	if what == "" {
+		return false
+	}
+	evm.fail(FAIL_BUDGET + ": " + what)
+	return true
+}
+
+// the analysis was aborted by its budget
+func (evm *evm) over_budget() bool {
+	return strings.HasPrefix(evm.fail_reason, FAIL_BUDGET)
+}
+
+// spends an interpreter step, false when the budget is exceeded
+func (evm *evm) step() bool {
+	return !evm.exceeded(evm.budget.step())
+}
+
+// spends an explored path, false when the budget is exceeded
+func (evm *evm) path() bool {
+	return !evm.exceeded(evm.budget.path())
+}
+
+// memory grows to size bytes, false when the budget is exceeded
+func (evm *evm) grow(memory *Memory, size uint64) bool {
+	if l := uint64(memory.Len()); size > l {
+		return !evm.exceeded(evm.budget.grow(size - l))
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/racytech/abs_evm/asm"
+)
+
+func TestBudgetExceeded(t *testing.T) {
+	defer func(l limits) { LIMITS = l }(LIMITS)
+
+	for _, c := range []struct {
+		name         string
+		code         string
+		steps, paths uint64
+		reason       string
+	}{
+		{"loop", loop_asm, 1000, 0, "budget exceeded: 1000 interpreter steps"},
+		{"dispatcher", dispatcher_asm(8), 0, 4, "budget exceeded: 4 explored paths"},
+	} {
+		LIMITS.Steps, LIMITS.Paths = c.steps, c.paths
+		_evm := run_code(code_run{code: asm.MustAssemble(c.code), address: bench_pair, pre: make(prestate)})
+		if _evm.result || _evm.fail_reason != c.reason {
+			t.Errorf("%s: result %t, reason %q, want %q", c.name, _evm.result, _evm.fail_reason, c.reason)
+		}
+		if status := new_tx_output(0, _evm).Status; status != "budget exceeded" {
+			t.Errorf("%s: status %q", c.name, status)
+		}
+	}
+}
+
+func TestBudgetTime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := new_budget(ctx, limits{})
+	if what := b.path(); what != "" {
+		t.Errorf("unlimited budget exceeded: %s", what)
+	}
+	cancel()
+	if what := b.path(); what != "context canceled" {
+		t.Errorf("cancelled budget: %q", what)
+	}
+
+	b = new_budget(context.Background(), limits{Time: duration(time.Millisecond)})
+	time.Sleep(2 * time.Millisecond)
+	for i := 0; i < budget_check-1; i++ {
+		if what := b.step(); what != "" {
+			t.Fatalf("time checked at step %d", i+1)
+		}
+	}
+	if what := b.step(); what != "time limit" {
+		t.Errorf("step %d: %q, want time limit", budget_check, what)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/racytech/abs_evm/asm"
+)
+
+const (
+	EDGE_TRUE        = "true"        // jump taken, JUMP or JUMPI
+	EDGE_FALSE       = "false"       // JUMPI not taken
+	EDGE_FALLTHROUGH = "fallthrough" // block ends where another one starts
+)
+
+// control flow graph explored by the graph mode in one exec frame. Built
+// from the vertices of new_graph, blocks are cut where another block
+// starts so that they do not overlap.
+type cfg struct {
+	Depth    int            `json:"depth"`
+	Address  common.Address `json:"address"`
+	CodeHash common.Hash    `json:"codeHash"`
+	CallPath string         `json:"callPath"`
+	Blocks   []*cfg_block   `json:"blocks"`
+	Edges    []cfg_edge     `json:"edges"`
+
+	code     []byte
+	vertices []cfg_vertex
+	visits   map[uint64]int // vertex start -> times it was run
+	edges    map[cfg_edge]bool
+}
+
+type cfg_vertex struct {
+	start, stop uint64
+	stack       []string
+}
+
+type cfg_block struct {
+	Start    uint64            `json:"start"`
+	Stop     uint64            `json:"stop"` // first pc after the block
+	Visits   int               `json:"visits"`
+	Opcodes  []cfg_instruction `json:"opcodes"`
+	Accesses []cfg_access      `json:"accesses"`
+	Stack    []string          `json:"stack,omitempty"` // on entry of the first run, bottom first
+}
+
+type cfg_instruction struct {
+	PC  uint64 `json:"pc"`
+	Op  string `json:"op"`
+	Arg string `json:"arg,omitempty"` // PUSH data
+}
+
+type cfg_edge struct {
+	From uint64 `json:"from"` // start of the blocks
+	To   uint64 `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// accesses the tree explorer recorded in a block
+type cfg_access struct {
+	Address common.Address `json:"address"`
+	Mode    string         `json:"mode"`
+	Opcode  string         `json:"opcode"`
+	PC      uint64         `json:"pc"`
+}
+
+// CFGs of every frame of a transaction
+type cfg_output struct {
+	Version int    `json:"version"`
+	Block   uint64 `json:"block"`
+	Tx      int    `json:"tx"`
+	Frames  []*cfg `json:"frames"`
+}
+
+func new_cfg(evm *evm, contract *Contract) *cfg {
+	g := &cfg{
+		Depth:  len(evm.frames) - 1,
+		code:   contract.Code,
+		visits: make(map[uint64]int),
+		edges:  make(map[cfg_edge]bool),
+	}
+	if len(evm.frames) > 0 {
+		top := evm.frames[len(evm.frames)-1]
+		g.Address, g.CodeHash, g.CallPath = top.address, top.code_hash, call_path(evm.frames)
+	}
+	return g
+}
+
+// records a run of vtx, the first run of a start defines the block
+func (g *cfg) visit(vtx *vertex) {
+	if g.visits[vtx.start] == 0 {
+		stack := make([]string, len(vtx.stack.Data))
+		for i := range vtx.stack.Data {
+			stack[i] = vtx.stack.Data[i].Hex()
+		}
+		g.vertices = append(g.vertices, cfg_vertex{vtx.start, vtx.stop, stack})
+	}
+	g.visits[vtx.start]++
+
+	if vtx.direction > -1 {
+		kind := EDGE_FALSE
+		if vtx.direction == 1 {
+			kind = EDGE_TRUE
+		}
+		g.edges[cfg_edge{From: vtx.parentID, To: vtx.start, Kind: kind}] = true
+	}
+}
+
+// cuts the vertices into non overlapping blocks
+func (g *cfg) build() {
+	stop := uint64(len(g.code))
+	starts := make(map[uint64]bool, len(g.vertices))
+	for _, v := range g.vertices {
+		starts[v.start] = true
+	}
+
+	blocks := make(map[uint64]*cfg_block)
+	last := make(map[uint64]uint64) // vertex start -> start of its last block
+	edges := make(map[cfg_edge]bool)
+
+	for _, v := range g.vertices {
+		var b *cfg_block
+		for _, ins := range asm.Disassemble(g.code, v.start, min_u64(v.stop, stop)) {
+			if b == nil || (ins.PC != b.Start && starts[ins.PC]) {
+				if b != nil {
+					b.Stop = ins.PC
+					edges[cfg_edge{From: b.Start, To: ins.PC, Kind: EDGE_FALLTHROUGH}] = true
+				}
+				if existing, ok := blocks[ins.PC]; ok {
+					existing.Visits += g.visits[v.start]
+					b = existing
+					b.Opcodes = b.Opcodes[:0]
+				} else {
+					b = &cfg_block{Start: ins.PC, Visits: g.visits[v.start]}
+					blocks[ins.PC] = b
+				}
+				if ins.PC == v.start {
+					b.Stack = v.stack
+				}
+			}
+			op := cfg_instruction{PC: ins.PC, Op: ins.Name()}
+			if len(ins.Arg) > 0 {
+				op.Arg = fmt.Sprintf("0x%x", ins.Arg)
+			}
+			b.Opcodes = append(b.Opcodes, op)
+			b.Stop = ins.PC + ins.Size()
+		}
+		if b != nil {
+			last[v.start] = b.Start
+		}
+	}
+
+	for e := range g.edges {
+		if from, ok := last[e.From]; ok {
+			e.From = from
+		}
+		if _, ok := blocks[e.To]; ok {
+			edges[e] = true
+		}
+	}
+
+	g.Blocks = make([]*cfg_block, 0, len(blocks))
+	for _, b := range blocks {
+		b.Accesses = []cfg_access{}
+		g.Blocks = append(g.Blocks, b)
+	}
+	sort.Slice(g.Blocks, func(i, j int) bool { return g.Blocks[i].Start < g.Blocks[j].Start })
+
+	g.Edges = make([]cfg_edge, 0, len(edges))
+	for e := range edges {
+		g.Edges = append(g.Edges, e)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+}
+
+func min_u64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// attaches the accesses the tree explorer made in this frame to the blocks
+func (g *cfg) add_accesses(r *report) {
+	for _, t := range r.accessPoints {
+		if t.call_path != g.CallPath {
+			continue
+		}
+		i := sort.Search(len(g.Blocks), func(i int) bool { return g.Blocks[i].Stop > t.pc })
+		if i == len(g.Blocks) || g.Blocks[i].Start > t.pc {
+			continue
+		}
+		g.Blocks[i].Accesses = append(g.Blocks[i].Accesses, cfg_access{
+			Address: t.addr,
+			Mode:    t.mode_name(),
+			Opcode:  asm.Name(t.instruction),
+			PC:      t.pc,
+		})
+	}
+	for _, b := range g.Blocks {
+		sort.Slice(b.Accesses, func(i, j int) bool {
+			if b.Accesses[i].PC != b.Accesses[j].PC {
+				return b.Accesses[i].PC < b.Accesses[j].PC
+			}
+			return b.Accesses[i].Address.Hex() < b.Accesses[j].Address.Hex()
+		})
+	}
+}
+
+func new_cfg_output(_evm *evm) cfg_output {
+	out := cfg_output{
+		Version: OUTPUT_VERSION,
+		Block:   _evm.report.blockNumber,
+		Tx:      _evm.report.txnIDX,
+		Frames:  []*cfg{},
+	}
+	for _, g := range _evm.cfgs {
+		g.add_accesses(_evm.report)
+		out.Frames = append(out.Frames, g)
+	}
+	return out
+}
+
+func write_cfg_json(w io.Writer, out cfg_output) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func xml_escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func block_text(b *cfg_block) string {
+	var lines []string
+	for _, op := range b.Opcodes {
+		lines = append(lines, strings.TrimSpace(fmt.Sprintf("%d %s %s", op.PC, op.Op, op.Arg)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func access_text(b *cfg_block) string {
+	var lines []string
+	for _, a := range b.Accesses {
+		lines = append(lines, fmt.Sprintf("%s %s at pc %d", a.Mode, a.Address.Hex(), a.PC))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// one graph per frame
+func write_cfg_graphml(w io.Writer, out cfg_output) error {
+	fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(w, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	for _, key := range []string{"start", "stop", "visits", "opcodes", "accesses"} {
+		typ := "long"
+		if key == "opcodes" || key == "accesses" {
+			typ = "string"
+		}
+		fmt.Fprintf(w, "  <key id=\"%s\" for=\"node\" attr.name=\"%s\" attr.type=\"%s\"/>\n", key, key, typ)
+	}
+	fmt.Fprintln(w, `  <key id="kind" for="edge" attr.name="kind" attr.type="string"/>`)
+
+	for i, g := range out.Frames {
+		fmt.Fprintf(w, "  <graph id=\"frame_%d\" edgedefault=\"directed\">\n", i)
+		fmt.Fprintf(w, "    <desc>block %d tx %d depth %d %s</desc>\n", out.Block, out.Tx, g.Depth, xml_escape(g.CallPath))
+		for _, b := range g.Blocks {
+			fmt.Fprintf(w, "    <node id=\"f%d_%d\">\n", i, b.Start)
+			fmt.Fprintf(w, "      <data key=\"start\">%d</data>\n", b.Start)
+			fmt.Fprintf(w, "      <data key=\"stop\">%d</data>\n", b.Stop)
+			fmt.Fprintf(w, "      <data key=\"visits\">%d</data>\n", b.Visits)
+			fmt.Fprintf(w, "      <data key=\"opcodes\">%s</data>\n", xml_escape(block_text(b)))
+			fmt.Fprintf(w, "      <data key=\"accesses\">%s</data>\n", xml_escape(access_text(b)))
+			fmt.Fprintln(w, "    </node>")
+		}
+		for _, e := range g.Edges {
+			fmt.Fprintf(w, "    <edge source=\"f%d_%d\" target=\"f%d_%d\"><data key=\"kind\">%s</data></edge>\n",
+				i, e.From, i, e.To, e.Kind)
+		}
+		fmt.Fprintln(w, "  </graph>")
+	}
+	_, err := fmt.Fprintln(w, "</graphml>")
+	return err
+}
+
+// markdown with a mermaid flowchart per frame
+func write_cfg_mermaid(w io.Writer, out cfg_output) error {
+	fmt.Fprintf(w, "# block %d, transaction %d\n", out.Block, out.Tx)
+	for _, g := range out.Frames {
+		fmt.Fprintf(w, "\n## depth %d: %s\n\n", g.Depth, g.CallPath)
+		fmt.Fprintln(w, "```mermaid")
+		fmt.Fprintln(w, "flowchart TD")
+		for _, b := range g.Blocks {
+			label := fmt.Sprintf("%d..%d, %d visits", b.Start, b.Stop-1, b.Visits)
+			for _, op := range b.Opcodes {
+				label += "<br/>" + strings.TrimSpace(op.Op+" "+op.Arg)
+			}
+			for _, a := range b.Accesses {
+				label += fmt.Sprintf("<br/><b>%s %s</b>", a.Mode, short_addr(a.Address))
+			}
+			fmt.Fprintf(w, "  b%d[\"%s\"]\n", b.Start, strings.ReplaceAll(label, `"`, "#quot;"))
+		}
+		for _, e := range g.Edges {
+			fmt.Fprintf(w, "  b%d -->|%s| b%d\n", e.From, e.Kind, e.To)
+		}
+		fmt.Fprintln(w, "```")
+	}
+	return nil
+}
+
+var cfg_writers = map[string]struct {
+	ext   string
+	write func(io.Writer, cfg_output) error
+}{
+	"json":    {"_cfg.json", write_cfg_json},
+	"graphml": {"_cfg.graphml", write_cfg_graphml},
+	"mermaid": {"_cfg.md", write_cfg_mermaid},
+	"html":    {"_cfg.html", write_cfg_html},
+}
+
+// comma separated list of -cfg formats
+func parse_cfg_formats(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	formats := strings.Split(s, ",")
+	for _, f := range formats {
+		if _, ok := cfg_writers[f]; !ok {
+			return nil, fmt.Errorf("unknown CFG format %q, expected json, graphml, mermaid or html", f)
+		}
+	}
+	return formats, nil
+}
+
+// writes <block>_<tx>_cfg.<ext> in every format, next to the dot files
+func write_cfgs(_evm *evm, formats []string) {
+	if len(formats) == 0 {
+		return
+	}
+	out := new_cfg_output(_evm)
+	name := strconv.FormatUint(out.Block, 10) + "_" + strconv.Itoa(out.Tx)
+	for _, format := range formats {
+		writer := cfg_writers[format]
+		f, err := os.Create(name + writer.ext)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writer.write(f, out); err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// single file viewer of the CFGs of a transaction, works offline: the
+// model is embedded as JSON and drawn as SVG by the script below
+func write_cfg_html(w io.Writer, out cfg_output) error {
+	data, err := json.Marshal(out) // escapes <, > and &
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, strings.Replace(cfg_html, "/*CFG*/", string(data), 1))
+	return err
+}
+
+const cfg_html = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>CFG</title>
+<style>
+body { margin: 0; font: 12px monospace; display: flex; height: 100vh; }
+#main { flex: 1; display: flex; flex-direction: column; }
+#bar { padding: 6px; border-bottom: 1px solid #ccc; display: flex; gap: 12px; align-items: center; }
+#graph { flex: 1; cursor: grab; background: #fafafa; }
+#panel { width: 380px; overflow: auto; border-left: 1px solid #ccc; padding: 6px; white-space: pre; }
+.node rect { fill: #fff; stroke: #555; }
+.node.sload rect { fill: #dbe9ff; }
+.node.balance rect { fill: #e3f6d8; }
+.node.call rect { fill: #ffe8c7; }
+.node.sstore rect { fill: #ffd6d6; }
+.plain .node rect { fill: #fff; }
+.node.match rect { stroke: #d400d4; stroke-width: 3; }
+.node.selected rect { stroke: #000; stroke-width: 3; }
+.edge { fill: none; stroke-width: 1.5; }
+.edge.true { stroke: #2a8a2a; }
+.edge.false { stroke: #c03030; }
+.edge.fallthrough { stroke: #888; stroke-dasharray: 4 3; }
+.legend span { padding: 0 4px; }
+</style>
+</head>
+<body>
+<div id="main">
+<div id="bar">
+<select id="frame"></select>
+<input id="search" placeholder="pc or opcode" size="14"> <span id="found"></span>
+<label><input type="checkbox" id="collapse"> collapse chains</label>
+<label><input type="checkbox" id="highlight" checked> highlight</label>
+<span class="legend"><span style="background:#dbe9ff">SLOAD</span><span style="background:#ffd6d6">SSTORE</span><span style="background:#ffe8c7">CALL</span><span style="background:#e3f6d8">BALANCE</span></span>
+</div>
+<svg id="graph"><defs>
+<marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto"><path d="M0,0 L10,5 L0,10 z" fill="#555"/></marker>
+</defs><g id="view"></g></svg>
+</div>
+<div id="panel">click a block</div>
+<script type="application/json" id="cfg">/*CFG*/</script>
+<script>
+"use strict";
+const data = JSON.parse(document.getElementById("cfg").textContent);
+const svg = document.getElementById("graph"), view = document.getElementById("view");
+const panel = document.getElementById("panel"), NS = "http://www.w3.org/2000/svg";
+const W = 170, H = 40, GX = 30, GY = 50;
+const CALLS = ["CALL", "CALLCODE", "DELEGATECALL", "STATICCALL"];
+let frame = 0, nodes = [], pos = new Map(), tx = 20, ty = 20, scale = 1;
+
+function push(m, k, v) { if (!m.has(k)) m.set(k, []); m.get(k).push(v); }
+
+function kinds(blocks) {
+  const s = new Set();
+  for (const b of blocks) for (const op of b.opcodes) {
+    if (op.op === "SLOAD") s.add("sload");
+    if (op.op === "SSTORE") s.add("sstore");
+    if (CALLS.includes(op.op)) s.add("call");
+    if (op.op === "BALANCE" || op.op === "SELFBALANCE") s.add("balance");
+  }
+  return s;
+}
+
+// nodes are blocks, or chains of blocks with a single edge between them
+function make_nodes(g, collapse) {
+  const by_start = new Map(g.blocks.map(b => [b.start, b]));
+  const out = new Map(), inn = new Map();
+  for (const e of g.edges) { push(out, e.from, e); push(inn, e.to, e); }
+  const chained = b => {
+    const i = inn.get(b.start) || [];
+    return collapse && i.length === 1 && (out.get(i[0].from) || []).length === 1 && i[0].from !== b.start;
+  };
+  const group = new Map(), result = [];
+  const follow = head => {
+    const node = { id: result.length, blocks: [] };
+    for (let b = head; b && !group.has(b.start); ) {
+      group.set(b.start, node.id);
+      node.blocks.push(b);
+      const o = out.get(b.start) || [];
+      const next = o.length === 1 ? by_start.get(o[0].to) : null;
+      b = next && chained(next) ? next : null;
+    }
+    result.push(node);
+  };
+  for (const b of g.blocks) if (!chained(b)) follow(b);
+  for (const b of g.blocks) if (!group.has(b.start)) follow(b); // cycles of chained blocks
+  const edges = [];
+  for (const e of g.edges) {
+    const f = group.get(e.from), t = group.get(e.to);
+    const fn = result[f];
+    const internal = f === t && fn.blocks.findIndex(b => b.start === e.to) === fn.blocks.findIndex(b => b.start === e.from) + 1;
+    if (!internal) edges.push({ from: f, to: t, kind: e.kind });
+  }
+  return { nodes: result, edges };
+}
+
+// layers by distance from the entry
+function layout(model) {
+  const out = new Map();
+  for (const e of model.edges) push(out, e.from, e.to);
+  const level = new Map();
+  const queue = model.nodes.length ? [0] : [];
+  level.set(0, 0);
+  while (queue.length) {
+    const n = queue.shift();
+    for (const t of out.get(n) || []) if (!level.has(t)) { level.set(t, level.get(n) + 1); queue.push(t); }
+  }
+  let max = Math.max(0, ...level.values());
+  for (const n of model.nodes) if (!level.has(n.id)) level.set(n.id, ++max);
+  const rows = new Map();
+  for (const n of model.nodes) push(rows, level.get(n.id), n);
+  const widest = Math.max(1, ...[...rows.values()].map(r => r.length));
+  pos = new Map();
+  for (const [l, row] of rows) {
+    row.sort((a, b) => a.blocks[0].start - b.blocks[0].start);
+    const off = (widest - row.length) * (W + GX) / 2;
+    row.forEach((n, i) => pos.set(n.id, { x: off + i * (W + GX), y: l * (H + GY) }));
+  }
+}
+
+function el(name, attrs, parent) {
+  const e = document.createElementNS(NS, name);
+  for (const k in attrs) e.setAttribute(k, attrs[k]);
+  if (parent) parent.appendChild(e);
+  return e;
+}
+
+function draw() {
+  const g = data.frames[frame];
+  const model = make_nodes(g, document.getElementById("collapse").checked);
+  nodes = model.nodes;
+  layout(model);
+  view.innerHTML = "";
+  svg.classList.toggle("plain", !document.getElementById("highlight").checked);
+  for (const e of model.edges) {
+    const a = pos.get(e.from), b = pos.get(e.to);
+    const x1 = a.x + W / 2, y1 = a.y + H, x2 = b.x + W / 2, y2 = b.y;
+    let d = "M" + x1 + "," + y1 + " L" + x2 + "," + y2;
+    if (b.y <= a.y) { // back edge
+      const cx = Math.max(a.x, b.x) + W + GX;
+      d = "M" + (a.x + W) + "," + (a.y + H / 2) + " C" + cx + "," + a.y + " " + cx + "," + b.y + " " + (b.x + W) + "," + (b.y + H / 2);
+    }
+    el("path", { d: d, class: "edge " + e.kind, "marker-end": "url(#arrow)" }, view);
+  }
+  for (const n of nodes) {
+    const p = pos.get(n.id), first = n.blocks[0], last = n.blocks[n.blocks.length - 1];
+    const k = kinds(n.blocks);
+    const grp = el("g", { class: "node " + [...k].join(" "), transform: "translate(" + p.x + "," + p.y + ")" }, view);
+    grp.dataset.id = n.id;
+    el("rect", { width: W, height: H, rx: 3 }, grp);
+    const visits = Math.max(...n.blocks.map(b => b.visits));
+    const ops = n.blocks.reduce((s, b) => s + b.opcodes.length, 0);
+    const accesses = n.blocks.reduce((s, b) => s + b.accesses.length, 0);
+    el("text", { x: 5, y: 15 }, grp).textContent = first.start + ".." + (last.stop - 1) + (n.blocks.length > 1 ? " (" + n.blocks.length + " blocks)" : "");
+    el("text", { x: 5, y: 31 }, grp).textContent = ops + " ops, " + visits + " visits" + (accesses ? ", " + accesses + " acc" : "");
+    grp.addEventListener("click", ev => { ev.stopPropagation(); show(n); });
+  }
+  search();
+  apply();
+}
+
+function show(n) {
+  for (const e of view.querySelectorAll(".node")) e.classList.toggle("selected", +e.dataset.id === n.id);
+  let s = "";
+  for (const b of n.blocks) {
+    s += "block " + b.start + ".." + (b.stop - 1) + ", " + b.visits + " visits\n\n";
+    for (const op of b.opcodes) s += String(op.pc).padStart(6) + "  " + op.op + (op.arg ? " " + op.arg : "") + "\n";
+    if (b.accesses.length) {
+      s += "\naccesses:\n";
+      for (const a of b.accesses) s += "  " + a.mode + " " + a.address + " (" + a.opcode + " at pc " + a.pc + ")\n";
+    }
+    if (b.stack) {
+      s += "\nstack on entry (top first):\n";
+      for (let i = b.stack.length - 1; i >= 0; i--) s += String(b.stack.length - 1 - i).padStart(4) + "  " + b.stack[i] + "\n";
+    }
+    s += "\n";
+  }
+  panel.textContent = s;
+}
+
+function search() {
+  const q = document.getElementById("search").value.trim().toUpperCase();
+  let found = [];
+  if (q) {
+    const pc = /^(0X[0-9A-F]+|[0-9]+)$/.test(q) ? parseInt(q) : -1;
+    found = nodes.filter(n => n.blocks.some(b => pc >= 0 ? b.start <= pc && pc < b.stop : b.opcodes.some(op => op.op.includes(q))));
+  }
+  const ids = new Set(found.map(n => n.id));
+  for (const e of view.querySelectorAll(".node")) e.classList.toggle("match", ids.has(+e.dataset.id));
+  document.getElementById("found").textContent = q ? found.length + " found" : "";
+  return found;
+}
+
+function apply() { view.setAttribute("transform", "translate(" + tx + "," + ty + ") scale(" + scale + ")"); }
+
+function center(n) {
+  const p = pos.get(n.id), r = svg.getBoundingClientRect();
+  tx = r.width / 2 - (p.x + W / 2) * scale;
+  ty = r.height / 2 - (p.y + H / 2) * scale;
+  apply();
+}
+
+svg.addEventListener("wheel", ev => {
+  ev.preventDefault();
+  const r = svg.getBoundingClientRect(), f = ev.deltaY < 0 ? 1.15 : 1 / 1.15;
+  const mx = ev.clientX - r.left, my = ev.clientY - r.top;
+  tx = mx - (mx - tx) * f; ty = my - (my - ty) * f; scale *= f;
+  apply();
+}, { passive: false });
+let drag = null;
+svg.addEventListener("mousedown", ev => { drag = { x: ev.clientX - tx, y: ev.clientY - ty }; svg.style.cursor = "grabbing"; });
+window.addEventListener("mousemove", ev => { if (drag) { tx = ev.clientX - drag.x; ty = ev.clientY - drag.y; apply(); } });
+window.addEventListener("mouseup", () => { drag = null; svg.style.cursor = "grab"; });
+
+const select = document.getElementById("frame");
+data.frames.forEach((g, i) => {
+  const o = document.createElement("option");
+  o.value = i;
+  o.textContent = "depth " + g.depth + ": " + g.callPath;
+  select.appendChild(o);
+});
+select.addEventListener("change", () => { frame = +select.value; draw(); });
+document.getElementById("collapse").addEventListener("change", draw);
+document.getElementById("highlight").addEventListener("change", draw);
+document.getElementById("search").addEventListener("input", search);
+document.getElementById("search").addEventListener("keydown", ev => {
+  if (ev.key === "Enter") { const f = search(); if (f.length) { center(f[0]); show(f[0]); } }
+});
+document.title = "CFG block " + data.block + " tx " + data.tx;
+if (data.frames.length) draw(); else panel.textContent = "no frames were explored";
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/racytech/abs_evm/asm"
+)
+
+func TestCFGModel(t *testing.T) {
+	*EXPLORER = EXPLORER_GRAPH
+	defer func() { *EXPLORER = EXPLORER_TREE }()
+
+	address := common.HexToAddress("0xc0de")
+	_evm := run_code(code_run{
+		code: asm.MustAssemble(`
+			PUSH1 0 CALLDATALOAD JUMPI @skip
+			PUSH1 0 SLOAD POP
+			skip:
+			PUSH1 1 PUSH1 0 SSTORE STOP
+		`),
+		address: address,
+		pre:     make(prestate),
+	})
+	out := new_cfg_output(_evm)
+	if len(out.Frames) != 1 {
+		t.Fatalf("%d frames, want 1", len(out.Frames))
+	}
+	g := out.Frames[0]
+	if g.Address != address || g.Depth != 0 {
+		t.Errorf("frame %s at depth %d", g.Address.Hex(), g.Depth)
+	}
+
+	type block struct {
+		start, stop uint64
+		visits      int
+		accesses    string
+	}
+	var blocks []block
+	for _, b := range g.Blocks {
+		var accesses []string
+		for _, a := range b.Accesses {
+			accesses = append(accesses, a.Opcode+" "+a.Mode)
+		}
+		blocks = append(blocks, block{b.Start, b.Stop, b.Visits, strings.Join(accesses, ",")})
+	}
+	// the false branch falls through into the jump destination
+	want_blocks := []block{{0, 6, 1, ""}, {6, 10, 1, "SLOAD read"}, {10, 17, 2, "SSTORE write"}}
+	if !reflect.DeepEqual(blocks, want_blocks) {
+		t.Errorf("blocks %v, want %v", blocks, want_blocks)
+	}
+	want_edges := []cfg_edge{{0, 6, EDGE_FALSE}, {0, 10, EDGE_TRUE}, {6, 10, EDGE_FALLTHROUGH}}
+	if !reflect.DeepEqual(g.Edges, want_edges) {
+		t.Errorf("edges %v, want %v", g.Edges, want_edges)
+	}
+	if ops := g.Blocks[0].Opcodes; len(ops) != 4 || ops[2] != (cfg_instruction{PC: 3, Op: "PUSH1", Arg: "0x0a"}) {
+		t.Errorf("unexpected opcodes %v", ops)
+	}
+
+	var buf bytes.Buffer
+	if err := write_cfg_json(&buf, out); err != nil {
+		t.Fatal(err)
+	}
+	var decoded cfg_output
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Frames[0].Blocks) != 3 {
+		t.Errorf("invalid JSON (%v):\n%s", err, buf.String())
+	}
+
+	buf.Reset()
+	write_cfg_graphml(&buf, out)
+	for _, s := range []string{`<node id="f0_10">`, `<data key="visits">2</data>`, `<edge source="f0_6" target="f0_10"><data key="kind">fallthrough</data></edge>`} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("GraphML has no %q:\n%s", s, buf.String())
+		}
+	}
+
+	buf.Reset()
+	write_cfg_mermaid(&buf, out)
+	for _, s := range []string{"flowchart TD", "b0 -->|true| b10", "b6 -->|fallthrough| b10", "<b>write 0x000000..c0DE</b>"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("mermaid has no %q:\n%s", s, buf.String())
+		}
+	}
+
+	if _, err := parse_cfg_formats("json,svg"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestCFGHTML(t *testing.T) {
+	*EXPLORER = EXPLORER_GRAPH
+	defer func() { *EXPLORER = EXPLORER_TREE }()
+
+	_evm := run_code(code_run{
+		code:    asm.MustAssemble("PUSH1 7 PUSH1 0 JUMPI @next next: STOP"),
+		address: common.HexToAddress("0xc0de"),
+		pre:     make(prestate),
+	})
+	out := new_cfg_output(_evm)
+	if b := out.Frames[0].Blocks[1]; b.Start != 7 || !reflect.DeepEqual(b.Stack, []string{"0x7"}) {
+		t.Errorf("block %d has stack %v, want [0x7]", b.Start, b.Stack)
+	}
+
+	var buf bytes.Buffer
+	if err := write_cfg_html(&buf, out); err != nil {
+		t.Fatal(err)
+	}
+	page := buf.String()
+	start := strings.Index(page, `id="cfg">`) + len(`id="cfg">`)
+	end := strings.Index(page[start:], "</script>")
+	var embedded cfg_output
+	if err := json.Unmarshal([]byte(page[start:start+end]), &embedded); err != nil {
+		t.Fatalf("embedded model: %v", err)
+	}
+	a, _ := json.Marshal(embedded.Frames)
+	b, _ := json.Marshal(out.Frames)
+	if !bytes.Equal(a, b) {
+		t.Errorf("embedded model differs:\n%s\n%s", a, b)
+	}
+	if strings.Contains(page, "<script src") || strings.Contains(page, "<link") {
+		t.Error("viewer loads external resources")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/racytech/abs_evm/asm"
+)
+
+// what is known about a code without running it. Shared by all frames
+// running the code, never modified once made.
+type code_analysis struct {
+	jumpdests []bool       // valid JUMPDEST pcs
+	blocks    []code_block // basic blocks in pc order
+	edges     []cfg_edge   // edges known from the code alone
+}
+
+// a block starts at a JUMPDEST or after JUMP, JUMPI and the halting
+// instructions; stop is the first pc after it
+type code_block struct {
+	start, stop uint64
+}
+
+func ends_block(op byte) bool {
+	return op == JUMP || op == JUMPI || op == STOP || op == RETURN ||
+		op == REVERT || op == INVALID || op == SELFDESTRUCT
+}
+
+func make_valid_jumpdests(bytecode *[]byte) []bool {
+	l := len(*bytecode)
+	s := make([]bool, l) // slice of valid jumpdests
+
+	for i := 0; i < l; {
+		opcode := (*bytecode)[i]
+
+		if opcode >= 0x60 && opcode <= 0x7F { // PUSH instractions
+			takes := opcode - 0x5F // how many bytes PUSH takes?
+			i += int(takes)
+		} else if opcode == 0x5B { // JUMPDEST
+			s[i] = true
+		}
+
+		i += 1 // opcode itself
+	}
+
+	return s
+}
+
+func analyse_code(code []byte) *code_analysis {
+	a := &code_analysis{jumpdests: make_valid_jumpdests(&code)}
+
+	var b *code_block
+	var last, prev asm.Instruction // last two instructions of b
+	end := func(stop uint64) {
+		if b == nil {
+			return
+		}
+		b.stop = stop
+		a.blocks = append(a.blocks, *b)
+
+		// PUSH dest JUMP(I) is the only jump known statically
+		if (last.Op == JUMP || last.Op == JUMPI) && prev.Op >= PUSH1 && prev.Op <= PUSH32 {
+			if dest := new(uint256.Int).SetBytes(prev.Arg); dest.IsUint64() && dest.Uint64() < uint64(len(code)) && a.jumpdests[dest.Uint64()] {
+				a.edges = append(a.edges, cfg_edge{From: b.start, To: dest.Uint64(), Kind: EDGE_TRUE})
+			}
+		}
+		if stop < uint64(len(code)) {
+			switch {
+			case last.Op == JUMPI:
+				a.edges = append(a.edges, cfg_edge{From: b.start, To: stop, Kind: EDGE_FALSE})
+			case !ends_block(last.Op):
+				a.edges = append(a.edges, cfg_edge{From: b.start, To: stop, Kind: EDGE_FALLTHROUGH})
+			}
+		}
+		b = nil
+	}
+	for _, ins := range asm.Disassemble(code, 0, uint64(len(code))) {
+		if ins.Op == JUMPDEST {
+			end(ins.PC)
+		}
+		if b == nil {
+			b = &code_block{start: ins.PC}
+			last = asm.Instruction{}
+		}
+		prev, last = last, ins
+		if ends_block(ins.Op) {
+			end(ins.PC + ins.Size())
+		}
+	}
+	end(uint64(len(code)))
+	return a
+}
+
+type code_cache_stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+
+// least recently used code analyses by code hash, safe for concurrent use
+type code_cache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // front is the most recently used
+	items map[common.Hash]*list.Element
+	stats code_cache_stats
+}
+
+type code_cache_item struct {
+	hash     common.Hash
+	analysis *code_analysis
+}
+
+func new_code_cache(size int) *code_cache {
+	return &code_cache{size: size, order: list.New(), items: make(map[common.Hash]*list.Element)}
+}
+
+// shared by all transactions and blocks, -codecache sets its size
+var code_analyses = new_code_cache(4096)
+
+// analysis of code, whose hash is hash; code without a hash (init code)
+// is analysed every time
+func (c *code_cache) get(hash common.Hash, code []byte) *code_analysis {
+	if hash == (common.Hash{}) {
+		return analyse_code(code)
+	}
+
+	c.mu.Lock()
+	if c.size <= 0 {
+		c.mu.Unlock()
+		return analyse_code(code)
+	}
+	if e, ok := c.items[hash]; ok {
+		c.order.MoveToFront(e)
+		c.stats.Hits++
+		c.mu.Unlock()
+		return e.Value.(*code_cache_item).analysis
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	// analysed without the lock, two workers may do it at once
+	a := analyse_code(code)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[hash]; ok {
+		return e.Value.(*code_cache_item).analysis
+	}
+	c.items[hash] = c.order.PushFront(&code_cache_item{hash, a})
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*code_cache_item).hash)
+		c.stats.Evictions++
+	}
+	return a
+}
+
+func (c *code_cache) resize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = size
+	for c.order.Len() > 0 && c.order.Len() > size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*code_cache_item).hash)
+	}
+}
+
+func (c *code_cache) get_stats() code_cache_stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.order.Len()
+	return s
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/racytech/abs_evm/asm"
+)
+
+func TestAnalyseCode(t *testing.T) {
+	code := asm.MustAssemble(`
+		PUSH1 0 SLOAD JUMPI @set
+		PUSH1 2 POP
+		next:
+		PUSH1 0 CALLDATALOAD JUMP
+		set:
+		STOP
+		PUSH1 1
+	`)
+	a := analyse_code(code)
+
+	want_blocks := []code_block{{0, 6}, {6, 9}, {9, 14}, {14, 16}, {16, 18}}
+	if len(a.blocks) != len(want_blocks) {
+		t.Fatalf("blocks %v, want %v", a.blocks, want_blocks)
+	}
+	for i, b := range want_blocks {
+		if a.blocks[i] != b {
+			t.Errorf("block %d: %v, want %v", i, a.blocks[i], b)
+		}
+	}
+
+	// the JUMP at 13 has no static target, the STOP no successor
+	want_edges := []cfg_edge{
+		{From: 0, To: 14, Kind: EDGE_TRUE},
+		{From: 0, To: 6, Kind: EDGE_FALSE},
+		{From: 6, To: 9, Kind: EDGE_FALLTHROUGH},
+	}
+	if len(a.edges) != len(want_edges) {
+		t.Fatalf("edges %v, want %v", a.edges, want_edges)
+	}
+	for i, e := range want_edges {
+		if a.edges[i] != e {
+			t.Errorf("edge %d: %v, want %v", i, a.edges[i], e)
+		}
+	}
+	for pc, valid := range a.jumpdests {
+		if valid != (pc == 9 || pc == 14) {
+			t.Errorf("pc %d valid jumpdest %t", pc, valid)
+		}
+	}
+}
+
+func TestCodeCache(t *testing.T) {
+	c := new_code_cache(2)
+	codes := [][]byte{{byte(JUMPDEST)}, {byte(PUSH1), byte(JUMPDEST)}, {byte(STOP)}}
+	hash := func(i int) common.Hash { return common.BytesToHash([]byte{byte(i + 1)}) }
+
+	first := c.get(hash(0), codes[0])
+	if c.get(hash(0), codes[0]) != first {
+		t.Error("cached analysis is not reused")
+	}
+	c.get(hash(1), codes[1])
+	c.get(hash(0), codes[0]) // 1 is now the least recently used
+	c.get(hash(2), codes[2])
+	if c.get(hash(0), codes[0]) != first {
+		t.Error("recently used analysis was evicted")
+	}
+	if c.get(hash(1), codes[1]).jumpdests[1] {
+		t.Error("PUSH1 data marked as a jump destination")
+	}
+
+	want := code_cache_stats{Hits: 3, Misses: 4, Evictions: 2, Entries: 2}
+	if s := c.get_stats(); s != want {
+		t.Errorf("stats %+v, want %+v", s, want)
+	}
+
+	// init code has no hash and is not cached
+	c.get(common.Hash{}, codes[2])
+	if s := c.get_stats(); s != want {
+		t.Errorf("code without a hash changed the stats: %+v", s)
+	}
+}
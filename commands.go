@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/racytech/abs_evm/asm"
+)
+
+// exit codes of the commands
+const (
+	EXIT_OK     = 0
+	EXIT_ERROR  = 1 // bad input or I/O error, log.Fatal exits with it too
+	EXIT_USAGE  = 2 // unknown command or flag, flags that do not go together
+	EXIT_FAILED = 3 // the analysis of a transaction failed or exceeded its budget
+)
+
+// groups of flags defined once on flag.CommandLine and taken by several
+// commands
+var (
+	SOURCE_FLAGS = []string{"chaindata", "block", "rpc", "rpccache", "blockjson", "prestate", "replay"}
+	CODE_FLAGS   = []string{"code", "input", "caller", "address", "value"}
+	LIMIT_FLAGS  = []string{"config", "maxdepth", "maxloop", "gas", "framememory", "pstack", "txtimeout", "maxsteps", "maxpaths", "maxmemory"}
+	RUN_FLAGS    = []string{"codecache", "summaries", "cpuprofile", "memprofile", "format"}
+	STORE_FLAGS  = []string{"store", "from", "to", "verdict", "touches", "format"}
+)
+
+// transactions whose analysis failed, printed so far
+var analysis_failures int
+
+type command struct {
+	name    string
+	args    string // what follows the flags in the usage line
+	summary string // line in the command list
+	help    string
+	flags   [][]string             // flags of flag.CommandLine it takes
+	define  func(fs *flag.FlagSet) // flags of its own
+	run     func(fs *flag.FlagSet) int
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:    "analyze",
+			summary: "analyse a block, a range of blocks, a raw transaction or bytecode",
+			help: "Prints the read and write sets of every transaction and whether the block can run in parallel.\n" +
+				"The block comes from -chaindata (-block, or -from/-to for a range), -rpc, -blockjson or -replay;\n" +
+				"-rawtx and -code analyse a single transaction.",
+			flags: [][]string{SOURCE_FLAGS, CODE_FLAGS, LIMIT_FLAGS, RUN_FLAGS,
+				{"snapshot", "rawtx", "from", "to", "loop", "store", "workers", "txworkers", "why", "tx", "coverage", "explorer", "graphviz", "cfg"}},
+			run: cmd_analyze,
+		},
+		{
+			name:    "explain",
+			summary: "explain why two transactions of a block conflict",
+			help:    "Prints every address two transactions of a block conflict on (RAW, WAR or WAW) and the accesses making it.",
+			flags:   [][]string{SOURCE_FLAGS, LIMIT_FLAGS, RUN_FLAGS, {"tx", "txworkers"}},
+			run:     cmd_explain,
+		},
+		{
+			name:    "cfg",
+			summary: "write the control flow graph the analysis explored",
+			help:    "Runs the graph mode and writes the CFG of every frame of every transaction to <block>_<tx>_cfg.*.",
+			flags:   [][]string{SOURCE_FLAGS, CODE_FLAGS, LIMIT_FLAGS, RUN_FLAGS, {"rawtx", "graphviz"}},
+			define: func(fs *flag.FlagSet) {
+				fs.StringVar(&CFG_COMMAND_FORMATS, "formats", "json", "json, graphml, mermaid and/or html (comma separated)")
+			},
+			run: cmd_cfg,
+		},
+		{
+			name:    "disasm",
+			args:    "[hex | @file]",
+			summary: "disassemble bytecode",
+			help:    "Prints the instructions of the code, one per line with its pc; the listing assembles back to the same bytes.",
+			flags:   [][]string{{"code"}},
+			define: func(fs *flag.FlagSet) {
+				fs.Uint64Var(&DISASM_START, "start", 0, "first pc")
+				fs.Uint64Var(&DISASM_STOP, "stop", math.MaxUint64, "pc to stop at")
+			},
+			run: cmd_disasm,
+		},
+		{
+			name:    "trace",
+			summary: "print the instructions the analysis runs",
+			help: "Analyses a block, a raw transaction or bytecode like analyze and prints every instruction the\n" +
+				"explorers run before the results: frame depth, address, pc, instruction and, with -stack, the stack.",
+			flags: [][]string{SOURCE_FLAGS, CODE_FLAGS, LIMIT_FLAGS, RUN_FLAGS, {"rawtx", "explorer"}},
+			define: func(fs *flag.FlagSet) {
+				fs.BoolVar(&TRACE_CALLS, "calls", false, "only trace calls and creates")
+				fs.BoolVar(&TRACE_STACK, "stack", false, "print the stack before each instruction, top last")
+				fs.Uint64Var(&TRACE_START, "startpc", 0, "only trace instructions from this pc")
+				fs.Uint64Var(&TRACE_STOP, "stoppc", math.MaxUint64, "only trace instructions up to this pc")
+			},
+			run: cmd_trace,
+		},
+		{
+			name:    "validate",
+			summary: "check input files and flags without analysing",
+			help:    "Reads every input given (-code, -input, -caller, -address, -value, -rawtx, -blockjson, -prestate, -replay,\n-config and the limit flags) and prints whether it is valid; the exit code is 1 if one is not.",
+			flags:   [][]string{CODE_FLAGS, LIMIT_FLAGS, {"rawtx", "blockjson", "prestate", "replay"}},
+			run:     cmd_validate,
+		},
+		{
+			name:    "stats",
+			summary: "summarise the blocks of a results store",
+			help:    "Counts the stored blocks matching the filters: range, missing blocks, verdicts and transactions.",
+			flags:   [][]string{STORE_FLAGS},
+			run:     cmd_stats,
+		},
+		{
+			name:    "query",
+			summary: "look up or list blocks of a results store",
+			help:    "Prints the stored -block, the blocks matching the filters with -list, a summary of them otherwise.",
+			flags:   [][]string{STORE_FLAGS, {"block", "list"}},
+			run:     cmd_query,
+		},
+	}
+}
+
+var (
+	DISASM_START uint64
+	DISASM_STOP  uint64 = math.MaxUint64
+
+	CFG_COMMAND_FORMATS = "json" // -formats of the cfg command
+)
+
+func program_name() string {
+	return filepath.Base(os.Args[0])
+}
+
+func find_command(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func print_commands(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s <command> [flags]\n\ncommands:\n", program_name())
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "  %-9s %s\n", "help", "print the flags of a command")
+	fmt.Fprintf(w, "\nRun \"%s <command> -help\" for the flags of a command. Without a command every flag is\naccepted and the run is an analyze.\n", program_name())
+}
+
+// the flag set of c, sharing the values of flag.CommandLine
+func (c *command) flag_set() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	for _, group := range c.flags {
+		for _, name := range group {
+			if fs.Lookup(name) == nil {
+				f := flag.CommandLine.Lookup(name)
+				fs.Var(f.Value, f.Name, f.Usage)
+			}
+		}
+	}
+	if c.define != nil {
+		c.define(fs)
+	}
+	fs.Usage = func() {
+		usage := strings.TrimSpace(fmt.Sprintf("%s %s [flags] %s", program_name(), c.name, c.args))
+		fmt.Fprintf(fs.Output(), "usage: %s\n\n%s\n\nflags:\n", usage, c.help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// runs the command line args (without the program name), returns the exit
+// code
+func run_cli(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+			print_commands(os.Stdout)
+			return EXIT_OK
+		}
+		// no command: every flag, as before there were commands
+		flag.CommandLine.Init(program_name(), flag.ContinueOnError)
+		flag.CommandLine.Usage = func() {
+			print_commands(flag.CommandLine.Output())
+			fmt.Fprintln(flag.CommandLine.Output(), "\nflags:")
+			flag.PrintDefaults()
+		}
+		return run_command(find_command("analyze"), flag.CommandLine, args)
+	}
+
+	if args[0] == "help" {
+		if len(args) > 1 {
+			if c := find_command(args[1]); c != nil {
+				fs := c.flag_set()
+				fs.SetOutput(os.Stdout)
+				fs.Usage()
+				return EXIT_OK
+			}
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[1])
+			print_commands(os.Stderr)
+			return EXIT_USAGE
+		}
+		print_commands(os.Stdout)
+		return EXIT_OK
+	}
+
+	c := find_command(args[0])
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		print_commands(os.Stderr)
+		return EXIT_USAGE
+	}
+	return run_command(c, c.flag_set(), args[1:])
+}
+
+func run_command(c *command, fs *flag.FlagSet, args []string) int {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return EXIT_OK
+		}
+		return EXIT_USAGE
+	}
+	if c.args == "" && fs.NArg() > 0 {
+		return usage_error(fs, "unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return c.run(fs)
+}
+
+func usage_error(fs *flag.FlagSet, format string, args ...interface{}) int {
+	fmt.Fprintf(fs.Output(), format+"\n", args...)
+	if fs == flag.CommandLine {
+		fmt.Fprintf(fs.Output(), "Run \"%s -help\" for the commands and flags.\n", program_name())
+	} else {
+		fmt.Fprintf(fs.Output(), "Run \"%s %s -help\" for its flags.\n", program_name(), fs.Name())
+	}
+	return EXIT_USAGE
+}
+
+// checks the flags every analysis takes and starts the profiles; stop is
+// nil when the flags are invalid
+func start_run(fs *flag.FlagSet) (stop func(), code int) {
+	if err := check_format(*FORMAT); err != nil {
+		return nil, usage_error(fs, "%s", err)
+	}
+	if err := load_limits(fs, *CONFIG); err != nil {
+		return nil, usage_error(fs, "Invalid limits: %s", err)
+	}
+	code_analyses.resize(*CODE_CACHE)
+	stop, err := start_profiles(*CPU_PROFILE, *MEM_PROFILE)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return stop, EXIT_OK
+}
+
+func analysis_exit_code() int {
+	if analysis_failures > 0 {
+		return EXIT_FAILED
+	}
+	return EXIT_OK
+}
+
+func cmd_analyze(fs *flag.FlagSet) int {
+	return analyze(fs, *CFG_FORMAT)
+}
+
+// analyze with the CFG written in cfg_formats
+func analyze(fs *flag.FlagSet, cfg_formats string) int {
+	stop_profiles, code := start_run(fs)
+	if stop_profiles == nil {
+		return code
+	}
+	defer stop_profiles()
+
+	if *FROM >= 0 && *TO >= 0 && *TO < *FROM {
+		return usage_error(fs, "-to has to be at least -from")
+	}
+	sources := given_sources()
+	if len(sources) > 1 {
+		return usage_error(fs, "One source at a time: %s given", strings.Join(sources, ", "))
+	}
+	if *SNAPSHOT != "" && len(sources) > 0 && sources[0] != "-rpc" {
+		return usage_error(fs, "-snapshot captures a -block from chaindata or -rpc, not %s", sources[0])
+	}
+	formats, err := parse_cfg_formats(cfg_formats)
+	if err != nil {
+		return usage_error(fs, "%s", err)
+	}
+	CFG_FORMATS = formats
+	if *EXPLORER != EXPLORER_TREE && *EXPLORER != EXPLORER_GRAPH {
+		return usage_error(fs, "Unknown explorer %q: tree or graph", *EXPLORER)
+	}
+	if explorer_mode() == EXPLORER_GRAPH && (*FROM >= 0 || *LOOP) {
+		return usage_error(fs, "-explorer=graph, -cfg and -graphviz do not go with -from or -loop")
+	}
+	if *GRAPHVIZ {
+		defer generagte_svg()
+	}
+
+	// Ctrl-C or SIGTERM stops the analysis, a range scan stops in order
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch {
+	case *CODE != "":
+		analize_code(ctx)
+	case *REPLAY != "":
+		replay_snapshot(ctx, *REPLAY)
+	case *BLOCK_JSON != "":
+		analize_block_json(ctx, *BLOCK_JSON, *PRESTATE)
+	case *FROM >= 0:
+		analize_blocks(ctx, *FROM, *TO)
+	case *BLOCK_INDEX < 0:
+		return usage_error(fs, "Nothing to analyse: give -block, -from, -blockjson, -replay or -code")
+	case *RAW_TX != "":
+		analize_raw_tx(ctx, *RAW_TX, *BLOCK_INDEX)
+	case *RPC_URL != "":
+		analize_block_rpc(ctx, *RPC_URL, *RPC_CACHE, *BLOCK_INDEX, *SNAPSHOT)
+	case *SNAPSHOT != "":
+		capture_block(ctx, *BLOCK_INDEX, *SNAPSHOT)
+	case *LOOP:
+		analize_blocks(ctx, *BLOCK_INDEX, -1)
+	default:
+		analize_block(ctx, *BLOCK_INDEX)
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "interrupted")
+	}
+	return analysis_exit_code()
+}
+
+// the flags naming what analyze analyses that were given, -block alone reads
+// chaindata. Like -prestate, -rpc is only the state of a -rawtx.
+func given_sources() []string {
+	var given []string
+	for _, s := range []struct {
+		name string
+		set  bool
+	}{
+		{"-code", *CODE != ""},
+		{"-replay", *REPLAY != ""},
+		{"-blockjson", *BLOCK_JSON != ""},
+		{"-from", *FROM >= 0},
+		{"-loop", *LOOP},
+		{"-rawtx", *RAW_TX != ""},
+		{"-rpc", *RPC_URL != "" && *RAW_TX == ""},
+	} {
+		if s.set {
+			given = append(given, s.name)
+		}
+	}
+	return given
+}
+
+func cmd_explain(fs *flag.FlagSet) int {
+	if len(TXS) != 2 || TXS[0] == TXS[1] {
+		return usage_error(fs, "explain needs a block and two different transactions: explain -block N -tx i -tx j")
+	}
+	EXPLAIN = true
+	return cmd_analyze(fs)
+}
+
+func cmd_cfg(fs *flag.FlagSet) int {
+	if CFG_COMMAND_FORMATS == "" {
+		return usage_error(fs, "-formats is empty")
+	}
+	return analyze(fs, CFG_COMMAND_FORMATS)
+}
+
+func cmd_trace(fs *flag.FlagSet) int {
+	TRACING = true
+	return cmd_analyze(fs)
+}
+
+func cmd_disasm(fs *flag.FlagSet) int {
+	arg := *CODE
+	if fs.NArg() > 0 {
+		arg = strings.Join(fs.Args(), "")
+	}
+	if arg == "" {
+		return usage_error(fs, "No code given")
+	}
+	code, err := read_code(arg)
+	if err != nil {
+		log.Fatal("Invalid code: ", err)
+	}
+	for _, ins := range asm.Disassemble(code, DISASM_START, DISASM_STOP) {
+		fmt.Printf("%-32s ; %d\n", ins.String(), ins.PC)
+	}
+	return EXIT_OK
+}
+
+// reads every input given, whether the analysis could start with them
+func cmd_validate(fs *flag.FlagSet) int {
+	type input struct {
+		flag  string
+		check func(v string) error
+	}
+	inputs := []input{
+		{"code", func(v string) error { _, err := read_code(v); return err }},
+		{"input", func(v string) error { _, err := decode_hex(v); return err }},
+		{"caller", func(v string) error { _, err := parse_address(v); return err }},
+		{"address", func(v string) error { _, err := parse_address(v); return err }},
+		{"value", func(v string) error { _, err := parse_quantity([]byte(v)); return err }},
+		{"rawtx", func(v string) error {
+			data, err := decode_hex(v)
+			if err == nil {
+				_, err = types.UnmarshalTransactionFromBinary(data)
+			}
+			return err
+		}},
+		{"blockjson", func(v string) error { _, err := read_block_json(v); return err }},
+		{"prestate", func(v string) error { _, err := read_prestate(v); return err }},
+		{"replay", func(v string) error { _, _, err := read_snapshot(v); return err }},
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	limits := false
+	for _, name := range LIMIT_FLAGS {
+		limits = limits || set[name]
+	}
+	if limits {
+		inputs = append(inputs, input{"limits", func(string) error { return load_limits(fs, *CONFIG) }})
+		set["limits"] = true
+	}
+
+	given, invalid := 0, 0
+	for _, in := range inputs {
+		if !set[in.flag] {
+			continue
+		}
+		given++
+		var value string
+		if f := fs.Lookup(in.flag); f != nil {
+			value = f.Value.String()
+		}
+		if err := in.check(value); err != nil {
+			invalid++
+			fmt.Printf("%s: invalid: %s\n", in.flag, err)
+		} else {
+			fmt.Printf("%s: ok\n", in.flag)
+		}
+	}
+	if given == 0 {
+		return usage_error(fs, "Nothing to validate")
+	}
+	if invalid > 0 {
+		return EXIT_ERROR
+	}
+	return EXIT_OK
+}
+
+func store_query(fs *flag.FlagSet) (query, int) {
+	if *STORE == "" {
+		return query{}, usage_error(fs, "%s needs a results store: -store=<dir>", fs.Name())
+	}
+	if err := check_format(*FORMAT); err != nil {
+		return query{}, usage_error(fs, "%s", err)
+	}
+	if err := check_verdict(*VERDICT); err != nil {
+		return query{}, usage_error(fs, "%s", err)
+	}
+	if *FROM >= 0 && *TO >= 0 && *TO < *FROM {
+		return query{}, usage_error(fs, "-to has to be at least -from")
+	}
+	q := query{from: 0, to: int64(*TO), verdict: *VERDICT}
+	if *FROM > 0 {
+		q.from = uint64(*FROM)
+	}
+	if *TOUCHES != "" {
+		addr, err := parse_address(*TOUCHES)
+		if err != nil {
+			return query{}, usage_error(fs, "Invalid address: %s", err)
+		}
+		q.touches = &addr
+	}
+	return q, EXIT_OK
+}
+
+func cmd_stats(fs *flag.FlagSet) int {
+	q, code := store_query(fs)
+	if code != EXIT_OK {
+		return code
+	}
+	run_query(*STORE, q, -1, false)
+	return EXIT_OK
+}
+
+func cmd_query(fs *flag.FlagSet) int {
+	q, code := store_query(fs)
+	if code != EXIT_OK {
+		return code
+	}
+	run_query(*STORE, q, *BLOCK_INDEX, *LIST)
+	return EXIT_OK
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common/hexutil"
+)
+
+// runs the command line with stdout discarded, every flag is reset after
+func run_cli_quiet(t *testing.T, args ...string) int {
+	stdout, stderr := os.Stdout, os.Stderr
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout, os.Stderr = null, null
+	defer func(l limits) {
+		os.Stdout, os.Stderr = stdout, stderr
+		null.Close()
+		flag.CommandLine.VisitAll(func(f *flag.Flag) {
+			if !strings.HasPrefix(f.Name, "test.") {
+				f.Value.Set(f.DefValue)
+			}
+		})
+		LIMITS, TXS, EXPLAIN, TRACING, analysis_failures = l, nil, false, false, 0
+		CFG_FORMATS = nil
+	}(LIMITS)
+	return run_cli(args)
+}
+
+func TestCommandExitCodes(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"help"}, EXIT_OK},
+		{[]string{"analyze", "-help"}, EXIT_OK},
+		{[]string{"frob"}, EXIT_USAGE},
+		{[]string{"analyze", "-nosuchflag"}, EXIT_USAGE},
+		{[]string{"analyze"}, EXIT_USAGE},
+		{[]string{"analyze", "-code=0x00", "-format=xml"}, EXIT_USAGE},
+		{[]string{"explain", "-block=1", "-tx=0"}, EXIT_USAGE},
+		{[]string{"stats"}, EXIT_USAGE},
+		{[]string{"disasm", "-code=0x00", "-why=0x01"}, EXIT_USAGE}, // not a flag of disasm
+		{[]string{"disasm", "0x6001600201"}, EXIT_OK},
+		{[]string{"analyze", "-code=0x6001600201"}, EXIT_OK},
+		{[]string{"analyze", "-code=0x6001600201", "-maxsteps=1"}, EXIT_FAILED},
+		{[]string{"analyze", "-code=0x6001600201", "-explorer=dfs"}, EXIT_USAGE},
+		{[]string{"analyze", "-from=1", "-explorer=graph"}, EXIT_USAGE},
+		{[]string{"trace", "-code=0x6001600201", "-explorer=graph"}, EXIT_OK},
+		{[]string{"analyze", "-code=0x6001600201", "-replay=block.snap"}, EXIT_USAGE},
+		{[]string{"analyze", "-from=1", "-loop"}, EXIT_USAGE},
+		{[]string{"analyze", "-from=1", "-cfg=json"}, EXIT_USAGE},
+		{[]string{"analyze", "-loop", "-block=1", "-graphviz"}, EXIT_USAGE},
+		{[]string{"analyze", "-code=0x6001600201", "-snapshot=block.snap"}, EXIT_USAGE},
+		{[]string{"validate"}, EXIT_USAGE},
+		{[]string{"validate", "-code=0x6001", "-caller=0x01"}, EXIT_OK},
+		{[]string{"validate", "-code=0x6001", "-input=0xzz"}, EXIT_ERROR},
+		{[]string{"validate", "-maxloop=-1"}, EXIT_ERROR},
+	}
+	for _, test := range tests {
+		if code := run_cli_quiet(t, test.args...); code != test.code {
+			t.Errorf("%s: exit code %d, want %d", strings.Join(test.args, " "), code, test.code)
+		}
+	}
+}
+
+func TestCommandFlagsShared(t *testing.T) {
+	run_cli_quiet(t, "validate", "-maxdepth=7")
+	if LIMITS.Depth != 4 {
+		t.Fatal("flags were not reset")
+	}
+
+	var depth int
+	defer func(run func(*flag.FlagSet) int) { find_command("validate").run = run }(find_command("validate").run)
+	find_command("validate").run = func(*flag.FlagSet) int { depth = LIMITS.Depth; return EXIT_OK }
+	run_cli_quiet(t, "validate", "-maxdepth=7")
+	if depth != 7 {
+		t.Errorf("-maxdepth of a command did not set the limit, depth %d", depth)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var out bytes.Buffer
+	trace_out = &out
+	defer func() { trace_out = os.Stdout }()
+
+	if code := run_cli_quiet(t, "trace", "-code=0x6001600201", "-stack", "-startpc=2"); code != EXIT_OK {
+		t.Fatalf("exit code %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d instructions traced, want 2:\n%s", len(lines), out.String())
+	}
+	if !strings.Contains(lines[0], "2 PUSH1 0x02") || !strings.HasSuffix(lines[0], "[0x1]") {
+		t.Errorf("unexpected trace line %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "4 ADD") || !strings.HasSuffix(lines[1], "[0x1 0x2]") {
+		t.Errorf("unexpected trace line %q", lines[1])
+	}
+}
+
+func TestCommandCFG(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	if code := run_cli_quiet(t, "cfg", "-code=0x6001600201"); code != EXIT_OK {
+		t.Fatalf("exit code %d", code)
+	}
+	if _, err := os.Stat("0_0_cfg.json"); err != nil {
+		t.Errorf("json is not the default format of cfg: %s", err)
+	}
+	if code := run_cli_quiet(t, "cfg", "-code=0x6001600201", "-formats="); code != EXIT_USAGE {
+		t.Errorf("empty -formats: exit code %d, want %d", code, EXIT_USAGE)
+	}
+}
+
+func TestCommandRawTxRPC(t *testing.T) {
+	f := load_fixture(t, filepath.Join("testdata", "fixtures", "complicated.json"))
+	server := httptest.NewServer(new_stub_node(t, f))
+	defer server.Close()
+
+	var raw bytes.Buffer
+	if err := f.make_blocks(t)[0].Transactions()[0].MarshalBinary(&raw); err != nil {
+		t.Fatal(err)
+	}
+	code := run_cli_quiet(t, "analyze", "-rawtx="+hexutil.Encode(raw.Bytes()), "-block=1", "-rpc="+server.URL)
+	if code != EXIT_OK && code != EXIT_FAILED {
+		t.Errorf("-rawtx with -rpc: exit code %d", code)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/racytech/abs_evm/asm"
+)
+
+const (
+	BRANCH_TRUE  uint8 = 1 << iota // JUMPI jump taken
+	BRANCH_FALSE                   // JUMPI not taken
+)
+
+// what the tree explorer covered of the code of one exec frame
+type coverage struct {
+	depth     int
+	address   common.Address
+	code_hash common.Hash
+	call_path string
+	code      []byte
+
+	seen     map[uint64]bool  // node starts the explorer has run
+	runs     map[uint64]int   // node start -> times the interpreter ran it
+	executed map[uint64]bool  // pcs of executed instructions
+	skipped  map[uint64]int   // node start -> revisits is_skippable skipped
+	branches map[uint64]uint8 // JUMPI pc -> explored edges
+}
+
+func new_coverage(evm *evm, contract *Contract) *coverage {
+	cov := &coverage{
+		depth:    len(evm.frames) - 1,
+		code:     contract.Code,
+		seen:     make(map[uint64]bool),
+		runs:     make(map[uint64]int),
+		executed: make(map[uint64]bool),
+		skipped:  make(map[uint64]int),
+		branches: make(map[uint64]uint8),
+	}
+	if len(evm.frames) > 0 {
+		top := evm.frames[len(evm.frames)-1]
+		cov.address, cov.code_hash, cov.call_path = top.address, top.code_hash, call_path(evm.frames)
+	}
+	return cov
+}
+
+// records a run of the interpreter from start that stopped at pc
+func (cov *coverage) run(start, pc, jump_dest uint64, is_jump bool) {
+	cov.runs[start]++
+	// the instruction at pc ran if it jumped or halted, not if it failed
+	last := pc
+	if !is_jump && jump_dest != REVERTS && jump_dest != HALTS {
+		if pc == 0 {
+			return
+		}
+		last = pc - 1
+	}
+	for pc := start; pc <= last && pc < uint64(len(cov.code)); {
+		cov.executed[pc] = true
+		pc += asm.Next(cov.code, pc).Size()
+	}
+}
+
+func (cov *coverage) branch(pc uint64, taken bool) {
+	if pc >= uint64(len(cov.code)) || cov.code[pc] != JUMPI {
+		return
+	}
+	if taken {
+		cov.branches[pc] |= BRANCH_TRUE
+	} else {
+		cov.branches[pc] |= BRANCH_FALSE
+	}
+}
+
+// static basic block of the code
+type coverage_block struct {
+	Start    uint64 `json:"start"`
+	Stop     uint64 `json:"stop"`   // first pc after the block
+	Status   string `json:"status"` // "explored" or "unreached"
+	Runs     int    `json:"runs"`   // interpreter runs starting at the block
+	Skipped  int    `json:"skipped"`
+	Executed int    `json:"executed"` // instructions
+	Size     int    `json:"size"`
+}
+
+type coverage_branch struct {
+	PC    uint64 `json:"pc"`
+	True  bool   `json:"true"`
+	False bool   `json:"false"`
+}
+
+type coverage_frame struct {
+	Depth        int               `json:"depth"`
+	Address      common.Address    `json:"address"`
+	CodeHash     common.Hash       `json:"codeHash"`
+	CallPath     string            `json:"callPath"`
+	Instructions int               `json:"instructions"`
+	Executed     int               `json:"executed"`
+	Blocks       []coverage_block  `json:"blocks"`
+	Branches     []coverage_branch `json:"branches"` // every JUMPI of the code
+}
+
+type coverage_output struct {
+	Version int              `json:"version"`
+	Block   uint64           `json:"block"`
+	Tx      int              `json:"tx"`
+	Frames  []coverage_frame `json:"frames"`
+}
+
+func (cov *coverage) frame() coverage_frame {
+	f := coverage_frame{
+		Depth:    cov.depth,
+		Address:  cov.address,
+		CodeHash: cov.code_hash,
+		CallPath: cov.call_path,
+		Blocks:   []coverage_block{},
+		Branches: []coverage_branch{},
+	}
+
+	for _, cb := range code_analyses.get(cov.code_hash, cov.code).blocks {
+		b := coverage_block{Start: cb.start, Stop: cb.stop, Status: "unreached", Runs: cov.runs[cb.start], Skipped: cov.skipped[cb.start]}
+		for _, ins := range asm.Disassemble(cov.code, cb.start, cb.stop) {
+			b.Size++
+			f.Instructions++
+			if cov.executed[ins.PC] {
+				b.Executed++
+				f.Executed++
+			}
+			if ins.Op == JUMPI {
+				explored := cov.branches[ins.PC]
+				f.Branches = append(f.Branches, coverage_branch{
+					PC:    ins.PC,
+					True:  explored&BRANCH_TRUE != 0,
+					False: explored&BRANCH_FALSE != 0,
+				})
+			}
+		}
+		if b.Executed > 0 {
+			b.Status = "explored"
+		}
+		f.Blocks = append(f.Blocks, b)
+	}
+	return f
+}
+
+// frames sorted by call path, so the caller comes before its callees
+func sorted_coverage(_evm *evm) []*coverage {
+	covs := append([]*coverage(nil), _evm.coverage...)
+	sort.SliceStable(covs, func(i, j int) bool { return covs[i].call_path < covs[j].call_path })
+	return covs
+}
+
+func new_coverage_output(_evm *evm) coverage_output {
+	out := coverage_output{
+		Version: OUTPUT_VERSION,
+		Block:   _evm.report.blockNumber,
+		Tx:      _evm.report.txnIDX,
+		Frames:  []coverage_frame{},
+	}
+	for _, cov := range sorted_coverage(_evm) {
+		out.Frames = append(out.Frames, cov.frame())
+	}
+	return out
+}
+
+func (f coverage_frame) summary() string {
+	explored, edges, explored_edges, skipped := 0, 0, 0, 0
+	for _, b := range f.Blocks {
+		if b.Status == "explored" {
+			explored++
+		}
+		skipped += b.Skipped
+	}
+	for _, br := range f.Branches {
+		edges += 2
+		if br.True {
+			explored_edges++
+		}
+		if br.False {
+			explored_edges++
+		}
+	}
+	return fmt.Sprintf("blocks %d/%d, instructions %d/%d, JUMPI edges %d/%d, skipped revisits %d",
+		explored, len(f.Blocks), f.Executed, f.Instructions, explored_edges, edges, skipped)
+}
+
+func yes_no(b bool) string {
+	if b {
+		return "explored"
+	}
+	return "not explored"
+}
+
+// annotated disassembly: '+' executed, '-' never reached
+func write_coverage(w io.Writer, format string, _evm *evm) error {
+	out := new_coverage_output(_evm)
+	if format != FORMAT_TEXT {
+		return write_json(w, format, out)
+	}
+
+	covs := sorted_coverage(_evm)
+	for i, f := range out.Frames {
+		cov := covs[i]
+		fmt.Fprintf(w, "\n**** coverage: tx %d, depth %d, %s ****\n", out.Tx, f.Depth, f.CallPath)
+		fmt.Fprintf(w, "code hash %s\n%s\n", f.CodeHash.Hex(), f.summary())
+
+		branches := make(map[uint64]coverage_branch, len(f.Branches))
+		for _, br := range f.Branches {
+			branches[br.PC] = br
+		}
+		for _, b := range f.Blocks {
+			note := []string{b.Status}
+			if b.Runs > 0 {
+				note = append(note, fmt.Sprintf("run %dx", b.Runs))
+			}
+			if b.Skipped > 0 {
+				note = append(note, fmt.Sprintf("skipped %dx", b.Skipped))
+			}
+			fmt.Fprintf(w, "\n  block %d..%d: %s\n", b.Start, b.Stop-1, strings.Join(note, ", "))
+			for _, ins := range asm.Disassemble(cov.code, b.Start, b.Stop) {
+				mark := "-"
+				if cov.executed[ins.PC] {
+					mark = "+"
+				}
+				line := fmt.Sprintf("%s %6d  %s", mark, ins.PC, ins)
+				if br, ok := branches[ins.PC]; ok {
+					line += fmt.Sprintf("    [true: %s, false: %s]", yes_no(br.True), yes_no(br.False))
+				}
+				fmt.Fprintln(w, line)
+			}
+		}
+	}
+	fmt.Fprintln(w)
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/racytech/abs_evm/asm"
+)
+
+func TestCoverage(t *testing.T) {
+	_evm := run_code(code_run{
+		code: asm.MustAssemble(`
+			PUSH1 0 SLOAD JUMPI @set
+			PUSH1 2 PUSH1 0 SSTORE STOP
+			set:
+			PUSH1 1 PUSH1 0 SSTORE STOP
+			dead:
+			PUSH1 0x0a BALANCE STOP
+		`),
+		address: common.HexToAddress("0xc0de"),
+		pre:     make(prestate),
+	})
+	if !_evm.result {
+		t.Fatalf("analysis failed: %s", _evm.fail_reason)
+	}
+
+	out := new_coverage_output(_evm)
+	if len(out.Frames) != 1 {
+		t.Fatalf("%d frames, want 1", len(out.Frames))
+	}
+	f := out.Frames[0]
+	if f.Instructions != 17 || f.Executed != 13 {
+		t.Errorf("executed %d of %d instructions, want 13 of 17", f.Executed, f.Instructions)
+	}
+	want := []coverage_block{
+		{Start: 0, Stop: 6, Status: "explored", Runs: 1, Executed: 4, Size: 4},
+		{Start: 6, Stop: 12, Status: "explored", Runs: 1, Executed: 4, Size: 4},
+		{Start: 12, Stop: 19, Status: "explored", Runs: 1, Executed: 5, Size: 5},
+		{Start: 19, Stop: 24, Status: "unreached", Executed: 0, Size: 4},
+	}
+	if len(f.Blocks) != len(want) {
+		t.Fatalf("blocks %+v, want %+v", f.Blocks, want)
+	}
+	for i := range want {
+		if f.Blocks[i] != want[i] {
+			t.Errorf("block %d: %+v, want %+v", i, f.Blocks[i], want[i])
+		}
+	}
+	if len(f.Branches) != 1 || f.Branches[0] != (coverage_branch{PC: 5, True: true, False: true}) {
+		t.Errorf("branches %+v, want both edges of the JUMPI at 5", f.Branches)
+	}
+
+	var b bytes.Buffer
+	if err := write_coverage(&b, FORMAT_TEXT, _evm); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"blocks 3/4, instructions 13/17, JUMPI edges 2/2",
+		"block 19..23: unreached",
+		"-     22  BALANCE",
+		"+      2  SLOAD",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("no %q in\n%s", line, b.String())
+		}
+	}
+}
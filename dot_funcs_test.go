@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/tests"
+)
+
+func TestTxsDot(t *testing.T) {
+	f := load_fixture(t, filepath.Join("testdata", "fixtures", "depend_exec.json"))
+	blocks := f.make_blocks(t)
+	chainCfg, _, _ := tests.GetChainConfig("London")
+	results := analize(context.Background(), blocks[0], new_alloc_state(f.alloc()), chainCfg, fixture_hashes(blocks))
+	handle_results(results, 1)
+
+	// both readers of B wait for the writer only
+	if waves := tx_waves(results); !reflect.DeepEqual(waves, []int{0, 1, 1}) {
+		t.Errorf("waves %v, want [0 1 1]", waves)
+	}
+
+	var buf bytes.Buffer
+	write_txs_dot(&buf, blocks[0], results)
+	dot := buf.String()
+	for _, s := range []string{
+		"digraph block_1 {",
+		"subgraph cluster_wave_1 {\nlabel=\"wave 1\"\ntx_1 ",
+		"tx_0 [label=\"tx 0\\nfrom 0x71562b..17F7\\nto 0x000000..00f1\\ngas 1000000\"]",
+		"tx_0 -> tx_1 [label=\"RAW 0x000000..000b\"]",
+		"tx_0 -> tx_2 [label=\"RAW 0x000000..000b\"]",
+	} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("dot file has no %q:\n%s", s, dot)
+		}
+	}
+	if strings.Contains(dot, "tx_1 -> tx_2") {
+		t.Errorf("readers depend on each other:\n%s", dot)
+	}
+}
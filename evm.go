@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"math/big"
 
 	"github.com/holiman/uint256"
@@ -14,12 +15,52 @@ import (
 const (
 	CREATE_ = 1 + iota
 	CREATE2_
+)
 
-	// since gas is unlimited some contracts can call to itself infinite number
-	// of times this number limits recursive calls
-	MAX_RECURSIONS = 10
+// reasons the analysis of a transaction is aborted
+const (
+	FAIL_DEPTH       = "too many nested exec frames"
+	FAIL_RETURN_MANY = "call has more than one possible return value"
+	FAIL_RETURN_NONE = "call has no return value"
+	FAIL_LOOP_FRAME  = "loop creates a new exec frame"
+	FAIL_LOOP        = "loop did not finish"
+	FAIL_BUDGET      = "budget exceeded" // followed by what was exceeded
+	FAIL_BASEFEE     = "base fee does not fit in 256 bits"
 )
 
+// explorers the analysis runs, -explorer
+const (
+	EXPLORER_TREE  = "tree"  // the tree explorer only
+	EXPLORER_GRAPH = "graph" // the graph of every frame as well
+)
+
+// the explorer the flags ask for, -cfg and -graphviz need the graph
+func explorer_mode() string {
+	if *EXPLORER == EXPLORER_GRAPH || *GRAPHVIZ || len(CFG_FORMATS) > 0 {
+		return EXPLORER_GRAPH
+	}
+	return EXPLORER_TREE
+}
+
+// reason for an interpreter error code
+func err_reason(code uint64) string {
+	switch code {
+	case STACK_UNDERFLOW:
+		return "stack underflow"
+	case STACK_OVERFLOW:
+		return "stack overflow"
+	case GAS_UNIT_OVERFLOW:
+		return "gas unit overflow"
+	case GAS_CONST_ERR:
+		return "memory exceeds gas limit"
+	case TOO_LARGE_MEM_ERR:
+		return "memory too large"
+	case INVALID_OP:
+		return "invalid opcode"
+	}
+	return "unknown error"
+}
+
 type access struct {
 	mode    int // -1 reads, 1 writes, 0 unknown
 	address common.Address
@@ -33,9 +74,11 @@ type evm struct {
 	interpreter *interpreter
 	origin      common.Address
 	gasprice    *big.Int
+	get_hash    func(uint64) common.Hash // canonical block hash by number, BLOCKHASH
 
-	abort  bool
-	result bool // general analysis result
+	abort       bool
+	result      bool   // general analysis result
+	fail_reason string // why the analysis was aborted, first reason only
 
 	rw_set      *rw_set     // set of read/write
 	return_data *byte_set   // return data of every exec frame (all calls)
@@ -43,10 +86,22 @@ type evm struct {
 	level       int         // currently executing frame level (recursion depth)
 	suicide     bool        // if there is possible selfdestruct
 
+	frames []frame // call chain of the executing frame
+	pc     uint64  // pc of the executing instruction
+	report *report // where every access comes from
+	cfgs   []*cfg  // graphs explored by the graph mode, one per frame
+
+	coverage []*coverage // what the tree explorer ran, one per frame
+
+	summaries *summary_cache // of the frames run in the block, nil if off
+	explorer  string         // EXPLORER_TREE or EXPLORER_GRAPH
+	limits    limits         // LIMITS when the evm was made
+	budget    *budget        // what the analysis may still spend
+
 	frame_errs map[int][]uint64
 }
 
-func new_evm(block *types.Block, state *state.IntraBlockState, chainCfg params.ChainConfig, msg types.Message) *evm {
+func new_evm(ctx context.Context, block *types.Block, state *state.IntraBlockState, chainCfg params.ChainConfig, msg types.Message) *evm {
 	origin := msg.From()
 	gasprice := msg.GasPrice().ToBig()
 	msg.Gas()
@@ -58,10 +113,15 @@ func new_evm(block *types.Block, state *state.IntraBlockState, chainCfg params.C
 		block: block, state: state, mstate: &mstate,
 		chainCfg: chainCfg, origin: origin,
 		gasprice: gasprice, level: -1,
+		get_hash:    func(uint64) common.Hash { return common.Hash{} },
 		frame_errs:  frame_errs,
 		return_data: new_byte_set(),
 		create_addr: new_create_set(),
 		rw_set:      new_set_addr(),
+		report:      newReport(block.NumberU64(), -1),
+		limits:      LIMITS,
+		budget:      new_budget(ctx, LIMITS),
+		explorer:    explorer_mode(),
 		result:      true, // true by default
 	}
 
@@ -70,6 +130,80 @@ func new_evm(block *types.Block, state *state.IntraBlockState, chainCfg params.C
 	return &_evm
 }
 
+// aborts the analysis of the transaction
+func (evm *evm) fail(reason string) {
+	if evm.fail_reason == "" {
+		evm.fail_reason = reason
+	}
+	evm.abort = true
+	evm.result = false
+}
+
+// value of BASEFEE, zero before London
+func (evm *evm) base_fee() *uint256.Int {
+	fee := evm.block.BaseFee()
+	if fee == nil {
+		return new(uint256.Int)
+	}
+	v, overflow := uint256.FromBig(fee)
+	if overflow {
+		evm.fail(FAIL_BASEFEE)
+	}
+	return v
+}
+
+// data returned by the last call of the executing frame, nil if none.
+// More than one possible value fails the analysis.
+func (evm *evm) last_return_data() []byte {
+	data := evm.return_data.get(evm.level + 1)
+	if len(data) > 1 {
+		evm.fail(FAIL_RETURN_MANY)
+		return nil
+	}
+	if len(data) == 1 {
+		return data[0]
+	}
+	return nil
+}
+
+// records an access by instruction op at pc of the executing frame
+func (evm *evm) access(pc uint64, op byte, addr common.Address, mode int) {
+	evm.access_in(evm.frames, pc, op, addr, mode)
+}
+
+func (evm *evm) access_in(frames []frame, pc uint64, op byte, addr common.Address, mode int) {
+	evm.rw_set.add(addr, mode)
+	evm.report.add(newTuple(frames, pc, op, addr, mode))
+	for _, s := range evm.mstate.recording {
+		below := append([]frame(nil), frames[s.base+1:]...)
+		s.accesses = append(s.accesses, summary_access{below, pc, op, addr, mode})
+	}
+}
+
+// a SELFDESTRUCT may run
+func (evm *evm) self_destruct() {
+	evm.suicide = true
+	for _, s := range evm.mstate.recording {
+		s.suicide = true
+	}
+}
+
+// op is the instruction creating the frame, it is at evm.pc of the parent
+func (evm *evm) enter(contract *Contract, op byte) {
+	f := frame{address: contract.Address(), code_hash: contract.CodeHash, op: op}
+	if f.code_hash == (common.Hash{}) { // init code of CREATE
+		f.code_hash = crypto.Keccak256Hash(contract.Code)
+	}
+	if len(evm.frames) > 0 {
+		f.pc = evm.pc
+	}
+	evm.frames = append(evm.frames, f)
+}
+
+func (evm *evm) leave() {
+	evm.frames = evm.frames[:len(evm.frames)-1]
+}
+
 func (evm *evm) call(caller ContractRef, addr common.Address, input []byte, value *uint256.Int) {
 	code := evm.state.GetCode(addr)
 	addrCopy := addr
@@ -77,14 +211,10 @@ func (evm *evm) call(caller ContractRef, addr common.Address, input []byte, valu
 	contract := new_contract(caller, AccountRef(addrCopy), value)
 	contract.set_call_code(&addrCopy, codehash, code)
 
+	evm.enter(contract, CALL)
 	evm.level += 1
-	if GRAPH {
-		new_graph(evm, contract, input)
-	}
-
-	if TREE {
-		new_tree(evm, contract, input)
-	}
+	evm.run_call(contract, input, CALL)
+	evm.leave()
 	evm.level -= 1
 }
 
@@ -95,14 +225,10 @@ func (evm *evm) call_code(caller ContractRef, addr common.Address, input []byte,
 	contract := new_contract(caller, AccountRef(addrCopy), value)
 	contract.set_call_code(&addrCopy, codehash, code)
 
+	evm.enter(contract, CALLCODE)
 	evm.level += 1
-	if GRAPH {
-		new_graph(evm, contract, input)
-	}
-
-	if TREE {
-		new_tree(evm, contract, input)
-	}
+	evm.run_call(contract, input, CALLCODE)
+	evm.leave()
 	evm.level -= 1
 }
 
@@ -113,14 +239,10 @@ func (evm *evm) delegate_call(caller ContractRef, addr common.Address, input []b
 	contract := new_contract(caller, AccountRef(addrCopy), new(uint256.Int))
 	contract.set_call_code(&addrCopy, codehash, code)
 
+	evm.enter(contract, DELEGATECALL)
 	evm.level += 1
-	if GRAPH {
-		new_graph(evm, contract, input)
-	}
-
-	if TREE {
-		new_tree(evm, contract, input)
-	}
+	evm.run_call(contract, input, DELEGATECALL)
+	evm.leave()
 	evm.level -= 1
 }
 
@@ -131,14 +253,10 @@ func (evm *evm) static_call(caller ContractRef, addr common.Address, input []byt
 	contract := new_contract(caller, AccountRef(addrCopy), new(uint256.Int))
 	contract.set_call_code(&addrCopy, codehash, code)
 
+	evm.enter(contract, STATICCALL)
 	evm.level += 1
-	if GRAPH {
-		new_graph(evm, contract, input)
-	}
-
-	if TREE {
-		new_tree(evm, contract, input)
-	}
+	evm.run_call(contract, input, STATICCALL)
+	evm.leave()
 	evm.level -= 1
 }
 
@@ -146,16 +264,16 @@ func (evm *evm) _create(caller ContractRef, codeAndHash *codeAndHash, value *uin
 	contract := new_contract(caller, AccountRef(address), value)
 	contract.set_code_hash(&address, codeAndHash)
 
+	op := byte(CREATE)
+	if calltype == CREATE2_ {
+		op = CREATE2
+	}
+	evm.enter(contract, op)
 	evm.level += 1
 	evm.create_addr.renew(evm.level)
 	evm.create_addr.set(evm.level, address)
-	if GRAPH {
-		new_graph(evm, contract, nil)
-	}
-
-	if TREE {
-		new_tree(evm, contract, nil)
-	}
+	evm.explore(contract, nil)
+	evm.leave()
 	evm.level -= 1
 }
 
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ledgerwatch/erigon/common"
+)
+
+// how two transactions of a block conflict on an address, first comes
+// before second in the block
+const (
+	RAW = "RAW" // second reads what first writes
+	WAR = "WAR" // second writes what first reads
+	WAW = "WAW" // both write
+)
+
+type pair_conflict struct {
+	addr common.Address
+	kind string
+}
+
+// modes of the accesses of first and second making the conflict
+func conflict_modes(kind string) (int, int) {
+	switch kind {
+	case RAW:
+		return WRITE, READ
+	case WAR:
+		return READ, WRITE
+	}
+	return WRITE, WRITE
+}
+
+// conflicts between transactions first < second, sorted by address and
+// kind. They are found from the access sets: the cross sets only hold the
+// edges ordering the block, not every conflicting pair.
+func pair_conflicts(results []*evm, first, second int) []pair_conflict {
+	a, b := results[first].rw_set, results[second].rw_set
+	var conflicts []pair_conflict
+	for addr := range a.write_set {
+		if b.has(addr, READ) {
+			conflicts = append(conflicts, pair_conflict{addr, RAW})
+		}
+		if b.has(addr, WRITE) {
+			conflicts = append(conflicts, pair_conflict{addr, WAW})
+		}
+	}
+	for addr := range a.read_set {
+		if b.has(addr, WRITE) {
+			conflicts = append(conflicts, pair_conflict{addr, WAR})
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		a, b := conflicts[i], conflicts[j]
+		if cmp := bytes.Compare(a.addr[:], b.addr[:]); cmp != 0 {
+			return cmp < 0
+		}
+		return a.kind < b.kind
+	})
+	return conflicts
+}
+
+func new_explain_output(block_number uint64, results []*evm, first, second int) explain_output {
+	out := explain_output{
+		Version:   OUTPUT_VERSION,
+		Block:     block_number,
+		First:     first,
+		Second:    second,
+		Limits:    LIMITS,
+		Conflicts: []conflict_explanation{},
+	}
+	for _, c := range pair_conflicts(results, first, second) {
+		first_mode, second_mode := conflict_modes(c.kind)
+		e := conflict_explanation{
+			Address: c.addr,
+			Kind:    c.kind,
+			First:   []access_output{},
+			Second:  []access_output{},
+		}
+		for _, t := range results[first].report.why(c.addr, first_mode) {
+			e.First = append(e.First, new_access_output(first, t))
+		}
+		for _, t := range results[second].report.why(c.addr, second_mode) {
+			e.Second = append(e.Second, new_access_output(second, t))
+		}
+		out.Conflicts = append(out.Conflicts, e)
+	}
+	return out
+}
+
+// explains why transactions i and j of the block conflict
+func write_explain(w io.Writer, format string, block_number uint64, results []*evm, i, j int) error {
+	if i > j {
+		i, j = j, i
+	}
+	out := new_explain_output(block_number, results, i, j)
+	if format != FORMAT_TEXT {
+		return write_json(w, format, out)
+	}
+
+	if len(out.Conflicts) == 0 {
+		fmt.Fprintf(w, "\ntransactions %d and %d of block #%d do not conflict\n", i, j, block_number)
+	} else {
+		fmt.Fprintf(w, "\ntransactions %d and %d of block #%d: %d conflicting keys\n", i, j, block_number, len(out.Conflicts))
+	}
+	fmt.Fprintln(w, "limits:", out.Limits)
+	for _, c := range out.Conflicts {
+		fmt.Fprintf(w, "\n%s %s\n", c.Kind, c.Address.Hex())
+		for _, side := range [][]access_output{c.First, c.Second} {
+			for _, a := range side {
+				fmt.Fprintf(w, "\ttx %d %s: %s at pc %d, frame %s, call path %s\n",
+					a.Tx, a.Mode, a.Opcode, a.PC, a.Frame.Hex(), a.CallPath)
+			}
+		}
+	}
+	for _, idx := range []int{i, j} {
+		if !results[idx].result {
+			fmt.Fprintf(w, "\ntx %d: analysis failed: %s, accesses may be missing\n", idx, results[idx].fail_reason)
+		}
+	}
+	fmt.Fprintln(w)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/tests"
+)
+
+func TestExplain(t *testing.T) {
+	f := load_fixture(t, filepath.Join("testdata", "fixtures", "depend_exec.json"))
+	blocks := f.make_blocks(t)
+	chainCfg, _, _ := tests.GetChainConfig("London")
+	results := analize(context.Background(), blocks[0], new_alloc_state(f.alloc()), chainCfg, fixture_hashes(blocks))
+	handle_results(results, 1)
+
+	// transactions are ordered by their position in the block
+	var buf bytes.Buffer
+	if err := write_explain(&buf, FORMAT_JSON, 1, results, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	var out explain_output
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.First != 0 || out.Second != 1 || len(out.Conflicts) != 1 {
+		t.Fatalf("unexpected explanation: %s", buf.String())
+	}
+
+	t1, b, t2 := f.Accounts["T1"].Address, f.Accounts["B"].Address, f.Accounts["T2"].Address
+	c := out.Conflicts[0]
+	if c.Address != b || c.Kind != RAW || len(c.First) != 1 || len(c.Second) != 1 {
+		t.Fatalf("unexpected conflict: %+v", c)
+	}
+	writer, reader := c.First[0], c.Second[0]
+	if writer.Tx != 0 || writer.Opcode != "SSTORE" || writer.PC != 4 || writer.Depth != 1 ||
+		writer.Frame != b || writer.CallPath != t1.Hex()+" -CALL@13-> "+b.Hex() {
+		t.Errorf("unexpected write: %+v", writer)
+	}
+	if reader.Tx != 1 || reader.Opcode != "BALANCE" || reader.PC != 2 || reader.Frame != t2 || reader.Mode != "read" {
+		t.Errorf("unexpected read: %+v", reader)
+	}
+
+	// both only read B
+	if conflicts := pair_conflicts(results, 1, 2); len(conflicts) != 0 {
+		t.Errorf("readers conflict: %+v", conflicts)
+	}
+}
+
+func TestPairConflictKinds(t *testing.T) {
+	a, b := common.HexToAddress("0x0a"), common.HexToAddress("0x0b")
+	first, second := &evm{rw_set: new_set_addr()}, &evm{rw_set: new_set_addr()}
+	first.rw_set.add(a, READ)
+	first.rw_set.add(b, WRITE)
+	second.rw_set.add(a, WRITE)
+	second.rw_set.add(b, READ)
+	second.rw_set.add(b, WRITE)
+	handle_results([]*evm{first, second}, 0)
+
+	want := []pair_conflict{{a, WAR}, {b, RAW}, {b, WAW}}
+	got := pair_conflicts([]*evm{first, second}, 0, 1)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
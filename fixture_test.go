@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+	"github.com/ledgerwatch/erigon/common/math"
+	"github.com/ledgerwatch/erigon/core"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/crypto"
+	"github.com/ledgerwatch/erigon/tests"
+	"github.com/racytech/abs_evm/asm"
+)
+
+// End to end scenario: a genesis allocation and blocks of transactions
+// analysed against in-memory state. Accounts are referred to by name
+// everywhere, the same way docs/01_transactions.md names them A, B, C...
+//
+// Every block is analysed against the genesis allocation, the analysis
+// itself never changes the state.
+type chain_fixture struct {
+	Description string                     `json:"description"`
+	Accounts    map[string]fixture_account `json:"accounts"`
+	Blocks      []fixture_block            `json:"blocks"`
+}
+
+// genesis account with a name, code may be given as assembly
+type fixture_account struct {
+	Address common.Address
+	Asm     string
+	core.GenesisAccount
+}
+
+type fixture_block struct {
+	Transactions []fixture_tx   `json:"transactions"`
+	Expect       fixture_expect `json:"expect"`
+}
+
+type fixture_tx struct {
+	From  string                `json:"from"` // account holding a secretKey
+	To    string                `json:"to"`   // empty creates a contract
+	Input hexutil.Bytes         `json:"input"`
+	Value *math.HexOrDecimal256 `json:"value"`
+	Gas   math.HexOrDecimal64   `json:"gas"`
+}
+
+type fixture_expect struct {
+	Independent bool             `json:"independent"`
+	Txs         []fixture_rw_set `json:"txs"`
+	Edges       []fixture_edge   `json:"edges"`
+}
+
+type fixture_rw_set struct {
+	Failed bool     `json:"failed"` // analysis of the transaction did not finish
+	Reads  []string `json:"reads"`
+	Writes []string `json:"writes"`
+}
+
+// transaction Tx reads (or writes) an address transaction On writes to
+type fixture_edge struct {
+	Tx   int    `json:"tx"`
+	On   int    `json:"on"`
+	Mode string `json:"mode"` // "read" or "write"
+}
+
+func (a *fixture_account) UnmarshalJSON(data []byte) error {
+	var named struct {
+		Address common.Address `json:"address"`
+		Asm     string         `json:"asm"`
+	}
+	if err := json.Unmarshal(data, &named); err != nil {
+		return err
+	}
+	// balance is the only required field of a genesis account
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if _, ok := fields["balance"]; !ok {
+		fields["balance"] = json.RawMessage(`"0x0"`)
+	}
+	data, _ = json.Marshal(fields)
+	if err := json.Unmarshal(data, &a.GenesisAccount); err != nil {
+		return err
+	}
+
+	a.Address, a.Asm = named.Address, named.Asm
+	if a.Asm != "" {
+		code, err := asm.Assemble(a.Asm)
+		if err != nil {
+			return err
+		}
+		a.Code = code
+	}
+	return nil
+}
+
+func load_fixture(t *testing.T, path string) *chain_fixture {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f chain_fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return &f
+}
+
+func (f *chain_fixture) alloc() core.GenesisAlloc {
+	alloc := make(core.GenesisAlloc)
+	for _, account := range f.Accounts {
+		alloc[account.Address] = account.GenesisAccount
+	}
+	return alloc
+}
+
+func (f *chain_fixture) name_of(addr common.Address) string {
+	for name, account := range f.Accounts {
+		if account.Address == addr {
+			return name
+		}
+	}
+	return addr.Hex()
+}
+
+// signs the transactions and packs them into blocks, numbered from 1.
+// nonces are taken from the allocation and increase across blocks.
+func (f *chain_fixture) make_blocks(t *testing.T) []*types.Block {
+	t.Helper()
+	chainCfg, _, _ := tests.GetChainConfig("London")
+	signer := types.LatestSignerForChainID(chainCfg.ChainID)
+
+	nonces := make(map[string]uint64)
+	for name, account := range f.Accounts {
+		nonces[name] = account.Nonce
+	}
+
+	var blocks []*types.Block
+	var parent common.Hash
+	for i, b := range f.Blocks {
+		var txs []types.Transaction
+		for j, tx := range b.Transactions {
+			from, ok := f.Accounts[tx.From]
+			if !ok || len(from.PrivateKey) == 0 {
+				t.Fatalf("block %d, tx %d: %q is not an account with a secretKey", i, j, tx.From)
+			}
+			key, err := crypto.ToECDSA(from.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			value := new(uint256.Int)
+			if tx.Value != nil {
+				value, _ = uint256.FromBig((*big.Int)(tx.Value))
+			}
+			gas := uint64(tx.Gas)
+			if gas == 0 {
+				gas = 1_000_000
+			}
+			gas_price := uint256.NewInt(1_000_000_000)
+
+			var txn types.Transaction
+			if tx.To == "" {
+				txn = types.NewContractCreation(nonces[tx.From], value, gas, gas_price, tx.Input)
+			} else {
+				to, ok := f.Accounts[tx.To]
+				if !ok {
+					t.Fatalf("block %d, tx %d: unknown account %q", i, j, tx.To)
+				}
+				txn = types.NewTransaction(nonces[tx.From], to.Address, value, gas, gas_price, tx.Input)
+			}
+			nonces[tx.From]++
+
+			signed, err := types.SignTx(txn, *signer, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			txs = append(txs, signed)
+		}
+
+		header := &types.Header{
+			ParentHash: parent,
+			Number:     big.NewInt(int64(i + 1)),
+			Difficulty: big.NewInt(0x020000),
+			GasLimit:   30_000_000,
+			BaseFee:    big.NewInt(7),
+		}
+		block := types.NewBlock(header, txs, nil, nil)
+		blocks = append(blocks, block)
+		parent = block.Hash()
+	}
+	return blocks
+}
+
+// hashes of the blocks built by make_blocks, genesis hash is empty
+func fixture_hashes(blocks []*types.Block) func(uint64) common.Hash {
+	return func(n uint64) common.Hash {
+		if n == 0 || n > uint64(len(blocks)) {
+			return common.Hash{}
+		}
+		return blocks[n-1].Hash()
+	}
+}
+
+// runs analize/handle_results on every block and checks the expectations
+func (f *chain_fixture) run(t *testing.T) {
+	t.Helper()
+	alloc := f.alloc()
+	chainCfg, _, _ := tests.GetChainConfig("London")
+
+	blocks := f.make_blocks(t)
+	for i, block := range blocks {
+		expect := f.Blocks[i].Expect
+		results := analize(context.Background(), block, new_alloc_state(alloc), chainCfg, fixture_hashes(blocks))
+		verdict := handle_results(results, int(block.NumberU64()))
+
+		if verdict != expect.Independent {
+			t.Errorf("block %d: independent = %t, want %t", i, verdict, expect.Independent)
+		}
+
+		if len(expect.Txs) > 0 && len(expect.Txs) != len(results) {
+			t.Fatalf("block %d: %d transactions analysed, %d expected", i, len(results), len(expect.Txs))
+		}
+		for j, want := range expect.Txs {
+			got := results[j]
+			if got.result == want.Failed {
+				t.Errorf("block %d, tx %d: failed = %t, want %t", i, j, !got.result, want.Failed)
+			}
+			f.check_set(t, fmt.Sprintf("block %d, tx %d: reads", i, j), got.rw_set.read_set, want.Reads)
+			f.check_set(t, fmt.Sprintf("block %d, tx %d: writes", i, j), got.rw_set.write_set, want.Writes)
+		}
+
+		got_edges := make(map[fixture_edge]bool)
+		for j, _evm := range results {
+			for _, c := range _evm.rw_set.cross_set {
+				mode := "read"
+				if c.mode == WRITE {
+					mode = "write"
+				}
+				got_edges[fixture_edge{Tx: j, On: c.txn_idx, Mode: mode}] = true
+			}
+		}
+		want_edges := make(map[fixture_edge]bool)
+		for _, e := range expect.Edges {
+			want_edges[e] = true
+		}
+		for e := range got_edges {
+			if !want_edges[e] {
+				t.Errorf("block %d: unexpected edge: tx %d %ss an address tx %d writes", i, e.Tx, e.Mode, e.On)
+			}
+		}
+		for e := range want_edges {
+			if !got_edges[e] {
+				t.Errorf("block %d: missing edge: tx %d %ss an address tx %d writes", i, e.Tx, e.Mode, e.On)
+			}
+		}
+	}
+}
+
+func (f *chain_fixture) check_set(t *testing.T, what string, got map[common.Address]bool, want []string) {
+	t.Helper()
+	var names []string
+	for addr := range got {
+		names = append(names, f.name_of(addr))
+	}
+	sort.Strings(names)
+	want = append([]string(nil), want...)
+	sort.Strings(want)
+
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("%s %v, want %v", what, names, want)
+	}
+}
+
+func TestChainFixtures(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "fixtures", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no fixtures found")
+	}
+
+	for _, path := range paths {
+		f := load_fixture(t, path)
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".json"), func(t *testing.T) {
+			t.Parallel() // blocks are analysed concurrently by -workers
+			f.run(t)
+		})
+	}
+}
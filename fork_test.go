@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func new_fork_ctx(items, mem int) *callCtx {
+	ctx := &callCtx{memory: NewMemory(), stack: &Stack{}, p_stack: &p_stack{}}
+	for i := 0; i < items; i++ {
+		ctx.stack.Push(uint256.NewInt(uint64(i)))
+		ctx.p_stack.push(KNOWN)
+	}
+	ctx.memory.Resize(uint64(mem))
+	return ctx
+}
+
+// what callCtx.copy did before forks shared their slices
+func deep_copy(ctx *callCtx) *callCtx {
+	return &callCtx{
+		memory:   &Memory{store: append([]byte(nil), ctx.memory.store...)},
+		stack:    &Stack{Data: append([]uint256.Int(nil), ctx.stack.Data...)},
+		p_stack:  &p_stack{data: append([]int(nil), ctx.p_stack.data...)},
+		contract: ctx.contract,
+	}
+}
+
+func TestForkIsolation(t *testing.T) {
+	ctx := new_fork_ctx(4, 64)
+	ctx.stack.Pop() // room in the shared backing array
+	ctx.p_stack.pop()
+	left := ctx.copy()
+	right := ctx.copy()
+
+	left.stack.Push(uint256.NewInt(100))
+	left.stack.Peek().SetUint64(101)
+	left.stack.Swap(2)
+	left.p_stack.push(UNKNOWN)
+	left.memory.Set32(0, uint256.NewInt(0xff))
+	left.memory.SetByte(40, 1)
+	left.memory.Resize(96)
+
+	right.stack.Pop()
+	right.stack.Push(uint256.NewInt(200))
+	right.p_stack.pop()
+	right.p_stack.push(UNKNOWN)
+
+	want := []uint64{0, 1, 2}
+	for i, v := range want {
+		if ctx.stack.Data[i].Uint64() != v {
+			t.Fatalf("parent stack changed: %v", ctx.stack.Data)
+		}
+	}
+	if ctx.p_stack.size() != 3 || *ctx.p_stack.peek() != KNOWN {
+		t.Errorf("parent p_stack changed: %v", ctx.p_stack.data)
+	}
+	if ctx.memory.Len() != 64 || ctx.memory.store[31] != 0 || ctx.memory.store[40] != 0 {
+		t.Errorf("parent memory changed: % x", ctx.memory.store)
+	}
+	if got := right.stack.Data; len(got) != 3 || got[1].Uint64() != 1 || got[2].Uint64() != 200 {
+		t.Errorf("right stack %v", got)
+	}
+	if got := left.stack.Data; len(got) != 4 || got[2].Uint64() != 101 || got[3].Uint64() != 2 {
+		t.Errorf("left stack %v", got)
+	}
+	if right.memory.store[31] != 0 || left.memory.store[31] != 0xff || left.memory.Len() != 96 {
+		t.Error("memory writes leaked between forks")
+	}
+
+	// the parent writes last, it owns the array the forks left behind
+	ctx.stack.Peek().SetUint64(7)
+	ctx.memory.SetByte(0, 7)
+	if right.stack.Data[1].Uint64() != 1 || right.memory.store[0] != 0 {
+		t.Error("parent writes leaked into a fork")
+	}
+}
+
+// a JUMPI forks the context, one side pops the condition and runs on,
+// the other writes to the stack and memory
+func bench_fork(b *testing.B, fork func(*callCtx) *callCtx) {
+	ctx := new_fork_ctx(32, 4096)
+	one := uint256.NewInt(1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		left := fork(ctx)
+		right := fork(ctx)
+		left.stack.Pop()
+		left.stack.Pop()
+		right.stack.Pop()
+		right.stack.Peek().Add(right.stack.Peek(), one)
+		right.memory.Set32(0, right.stack.Back(1))
+	}
+}
+
+func BenchmarkForkCopyOnWrite(b *testing.B) {
+	bench_fork(b, (*callCtx).copy)
+}
+
+func BenchmarkForkDeepCopy(b *testing.B) {
+	bench_fork(b, deep_copy)
+}
@@ -10,17 +10,17 @@ require (
 	github.com/consensys/gurvy v0.3.8 // indirect
 	github.com/go-sql-driver/mysql v1.5.0 // indirect
 	github.com/gofrs/flock v0.8.1 // indirect
-	github.com/holiman/uint256 v1.2.0 // indirect
-	github.com/ledgerwatch/erigon v0.0.0-20210808021841-72e9660b74d5 // indirect
-	github.com/ledgerwatch/erigon-lib v0.0.0-20210807132941-e9d58fa19e21 // indirect
-	github.com/ledgerwatch/log/v3 v3.2.0 // indirect
+	github.com/holiman/uint256 v1.2.0
+	github.com/ledgerwatch/erigon v0.0.0-20210808021841-72e9660b74d5
+	github.com/ledgerwatch/erigon-lib v0.0.0-20210807132941-e9d58fa19e21
+	github.com/ledgerwatch/log/v3 v3.2.0
 	github.com/logrusorgru/aurora v2.0.3+incompatible // indirect
 	github.com/logrusorgru/aurora/v3 v3.0.0 // indirect
 	github.com/prometheus/client_golang v1.9.0 // indirect
 	github.com/prometheus/tsdb v0.10.0 // indirect
 	github.com/wcharczuk/go-chart v2.0.1+incompatible // indirect
 	github.com/wcharczuk/go-chart/v2 v2.1.0 // indirect
-	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97 // indirect
+	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97
 	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4 // indirect
 	golang.org/x/sys v0.0.0-20210806184541-e5e7981a1069 // indirect
 )
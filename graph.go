@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"io"
+
+	"github.com/holiman/uint256"
 )
 
 type graph struct {
@@ -33,7 +36,8 @@ func new_vtx(start, parentID uint64, direction int) *vertex {
 func (vtx *vertex) set_ctx(ctx *callCtx) *vertex {
 	vtx.ctx = ctx
 	vtx.stack_size = ctx.stack.Len()
-	vtx.stack = *ctx.stack
+	// snapshot, the stack of ctx changes when the vertex runs
+	vtx.stack = Stack{Data: append([]uint256.Int(nil), ctx.stack.Data...)}
 	return vtx
 }
 
@@ -54,8 +58,6 @@ func (vtx *vertex) run(evm *evm, valid_jumpdests *[]bool, code_size *uint64) {
 }
 
 func new_graph(evm *evm, contract *Contract, input []byte) {
-	fmt.Println("*******************CREATING A GRAPH*******************")
-
 	bytecode := contract.Code
 	code_size := uint64(len(bytecode))
 
@@ -64,7 +66,7 @@ func new_graph(evm *evm, contract *Contract, input []byte) {
 		return
 	}
 
-	valid_jumpdests := make_valid_jumpdests(&bytecode)
+	valid_jumpdests := code_analyses.get(contract.CodeHash, bytecode).jumpdests
 
 	callContext := &callCtx{
 		memory:   NewMemory(),
@@ -72,8 +74,18 @@ func new_graph(evm *evm, contract *Contract, input []byte) {
 		contract: contract,
 	}
 
-	f := make_dot_file()
-	defer f.Close()
+	var f io.Writer = io.Discard
+	if *GRAPHVIZ {
+		file := make_dot_file(evm)
+		defer file.Close()
+		f = file
+	}
+
+	g := new_cfg(evm, contract)
+	defer func() {
+		g.build()
+		evm.cfgs = append(evm.cfgs, g)
+	}()
 
 	visited_map := make(map[uint64]*vertex)
 
@@ -82,7 +94,7 @@ func new_graph(evm *evm, contract *Contract, input []byte) {
 	vtxs := []*vertex{root}
 
 	var vtx *vertex
-	for len(vtxs) > 0 {
+	for len(vtxs) > 0 && !evm.abort && evm.path() {
 		vtx, vtxs = vtxs[0], vtxs[1:]
 
 		if _, ok := visited_map[vtx.start]; !ok {
@@ -92,6 +104,7 @@ func new_graph(evm *evm, contract *Contract, input []byte) {
 			pc := vtx.start
 			jump_dest, is_jump := evm.interpreter.g_run(&pc, vtx.ctx)
 			vtx.stop = pc + 1
+			g.visit(vtx)
 
 			jump_flag := false
 			if jump_dest < code_size && valid_jumpdests[jump_dest] {
@@ -127,12 +140,11 @@ func new_graph(evm *evm, contract *Contract, input []byte) {
 		} else {
 
 			vtx.run(evm, &valid_jumpdests, &code_size)
+			g.visit(vtx)
 
 			write_vtx(f, &bytecode, vtx)
 		}
 	}
 
-	if f != nil {
-		fmt.Fprint(f, "}\n")
-	}
+	fmt.Fprint(f, "}\n")
 }
@@ -1,8 +1,6 @@
 package main
 
 import (
-	"fmt"
-
 	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/erigon/common"
 	"golang.org/x/crypto/sha3"
@@ -397,36 +395,15 @@ func g_EXTCODECOPY(pc *uint64, intrprtr *interpreter, callContext *callCtx) uint
 	return 0
 }
 
+// the graph mode does not run calls, there is no return data
 func g_RETURNDATASIZE(pc *uint64, intrprtr *interpreter, callContext *callCtx) uint64 {
-	// TODO
-	// callContext.stack.Push(new(uint256.Int).SetUint64(uint64(len(intrprtr.returnData))))
+	callContext.stack.Push(new(uint256.Int))
 	return 0
 }
 
 func g_RETURNDATACOPY(pc *uint64, intrprtr *interpreter, callContext *callCtx) uint64 {
-	// TODO
-	// var (
-	// 	memOffset  = callContext.stack.Pop()
-	// 	dataOffset = callContext.stack.Pop()
-	// 	length     = callContext.stack.Pop()
-	// )
-
-	// offset64, overflow := dataOffset.Uint64WithOverflow()
-	// if overflow {
-	// 	// return nil, ErrReturnDataOutOfBounds
-	// }
-	// // we can reuse dataOffset now (aliasing it for clarity)
-	// end := dataOffset
-	// _, overflow = end.AddOverflow(&dataOffset, &length)
-	// if overflow {
-	// 	// return nil, ErrReturnDataOutOfBounds
-	// }
-
-	// end64, overflow := end.Uint64WithOverflow()
-	// if overflow || uint64(len(intrprtr.returnData)) < end64 {
-	// 	return nil, _errReturnDataOutOfBounds
-	// }
-	// callContext.memory.Set(memOffset.Uint64(), length.Uint64(), intrprtr.returnData[offset64:end64])
+	mem_offset, _, length := callContext.stack.Pop(), callContext.stack.Pop(), callContext.stack.Pop()
+	callContext.memory.Set(mem_offset.Uint64(), length.Uint64(), getData(nil, 0, length.Uint64()))
 	return 0
 }
 
@@ -464,7 +441,7 @@ func g_BLOCKHASH(pc *uint64, intrprtr *interpreter, callContext *callCtx) uint64
 		lower = upper - 256
 	}
 	if num64 >= lower && num64 < upper {
-		num.SetBytes(intrprtr.evm.block.Hash().Bytes())
+		num.SetBytes(intrprtr.evm.get_hash(num64).Bytes())
 	} else {
 		num.Clear()
 	}
@@ -504,12 +481,18 @@ func g_GASLIMIT(pc *uint64, intrprtr *interpreter, callContext *callCtx) uint64
 }
 
 func g_CHAINID(pc *uint64, intrprtr *interpreter, callContext *callCtx) uint64 {
-	fmt.Println("----------- NOT IMPLEMENTED(op_CHAINID) -------------")
+	chainID, _ := uint256.FromBig(intrprtr.evm.chainCfg.ChainID)
+	callContext.stack.Push(chainID)
 	return 0
 }
 
 func g_SELFBALANCE(pc *uint64, intrprtr *interpreter, callContext *callCtx) uint64 {
-	fmt.Println("----------- NOT IMPLEMENTED(op_SELFBALANCE) -------------")
+	callContext.stack.Push(intrprtr.evm.state.GetBalance(callContext.contract.Address()))
+	return 0
+}
+
+func g_BASEFEE(pc *uint64, intrprtr *interpreter, callContext *callCtx) uint64 {
+	callContext.stack.Push(intrprtr.evm.base_fee())
 	return 0
 }
 
@@ -535,7 +518,7 @@ func g_MSTORE(pc *uint64, intrprtr *interpreter, callContext *callCtx) uint64 {
 
 func g_MSTORE8(pc *uint64, intrprtr *interpreter, callContext *callCtx) uint64 {
 	off, val := callContext.stack.Pop(), callContext.stack.Pop()
-	callContext.memory.store[off.Uint64()] = byte(val.Uint64())
+	callContext.memory.SetByte(off.Uint64(), byte(val.Uint64()))
 	return 0
 }
 
@@ -598,8 +581,7 @@ func g_MSIZE(pc *uint64, intrprtr *interpreter, callContext *callCtx) uint64 {
 }
 
 func g_GAS(pc *uint64, intrprtr *interpreter, callContext *callCtx) uint64 {
-	const _gas = uint64(100_000_000_000)
-	callContext.stack.Push(new(uint256.Int).SetUint64(_gas))
+	callContext.stack.Push(new(uint256.Int).SetUint64(intrprtr.evm.limits.Gas))
 	return 0
 }
 
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/core/rawdb"
+	"github.com/ledgerwatch/erigon/core/state"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/tests"
+	log_ "github.com/ledgerwatch/log/v3"
+	"github.com/racytech/abs_evm/asm"
+)
+
+// a message call to arbitrary code, no block or transaction required
+type code_run struct {
+	code    []byte
+	input   []byte
+	caller  common.Address
+	address common.Address // the code is installed at this address
+	value   *uint256.Int
+	number  uint64   // block number
+	pre     prestate // optional state the code runs on
+}
+
+// runs the tree explorer (and the graph builder with -explorer=graph) over
+// the code
+func run_code(r code_run) *evm {
+	return r.prepare()(context.Background())
+}
+
+// sets up the block, message and state of the call, the returned function
+// analyses it until ctx is done. The state is only read, every analysis may run on it.
+func (r code_run) prepare() func(ctx context.Context) *evm {
+	alloc := r.pre.alloc()
+	account := alloc[r.address]
+	if account.Balance == nil {
+		account.Balance = new(big.Int)
+	}
+	account.Code = r.code
+	alloc[r.address] = account
+
+	block := types.NewBlockWithHeader(&types.Header{
+		Number:     new(big.Int).SetUint64(r.number),
+		Difficulty: new(big.Int),
+		BaseFee:    new(big.Int),
+	})
+	chainCfg, _, _ := tests.GetChainConfig("London")
+
+	value := r.value
+	if value == nil {
+		value = new(uint256.Int)
+	}
+	msg := types.NewMessage(r.caller, &r.address, 0, value, 0, new(uint256.Int),
+		new(uint256.Int), new(uint256.Int), r.input, nil, false)
+
+	ibs := new_alloc_state(alloc)
+	return func(ctx context.Context) *evm {
+		_evm := new_evm(ctx, block, ibs, *chainCfg, msg)
+		_evm.report.txnIDX = 0
+		_evm.summaries = new_summary_cache()
+		_evm.call(AccountRef(r.caller), r.address, r.input, value)
+		if _evm.explorer == EXPLORER_GRAPH {
+			write_cfgs(_evm, CFG_FORMATS)
+		}
+		return _evm
+	}
+}
+
+// bytecode from hex, or from a file when arg starts with '@'. Files hold
+// hex, assembly (*.asm) or raw bytes.
+func read_code(arg string) ([]byte, error) {
+	if !strings.HasPrefix(arg, "@") {
+		return decode_hex(arg)
+	}
+
+	path := arg[1:]
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".asm") {
+		return asm.Assemble(string(data))
+	}
+	if code, err := decode_hex(string(data)); err == nil {
+		return code, nil
+	}
+	return data, nil
+}
+
+func decode_hex(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	return hex.DecodeString(s)
+}
+
+// hex address, leading zeros may be omitted
+func parse_address(s string) (common.Address, error) {
+	b, err := decode_hex(s)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if len(b) > common.AddressLength {
+		return common.Address{}, fmt.Errorf("%s is longer than %d bytes", s, common.AddressLength)
+	}
+	return common.BytesToAddress(b), nil
+}
+
+// hash is nil when there is no transaction
+func print_evm_result(_evm *evm, hash *common.Hash) {
+	if !_evm.result {
+		analysis_failures++
+	}
+	if *FORMAT != FORMAT_TEXT {
+		out := new_tx_output(0, _evm)
+		out.Hash = hash
+		out.Limits = &_evm.limits
+		if err := write_json(os.Stdout, *FORMAT, out); err != nil {
+			log.Fatal(err)
+		}
+		if *COVERAGE {
+			print_coverage(_evm)
+		}
+		return
+	}
+
+	if _evm.result {
+		fmt.Println("\nanalysis finished")
+	} else {
+		fmt.Println("\nanalysis failed:", _evm.fail_reason)
+	}
+	if _evm.suicide {
+		fmt.Println("possible selfdestruct")
+	}
+	fmt.Println("limits:", _evm.limits)
+	_evm.rw_set.print(0)
+	fmt.Println()
+	if *COVERAGE {
+		print_coverage(_evm)
+	}
+}
+
+// analyses a signed raw transaction as the only transaction of block
+// block_number, on the state the block starts with
+func analize_raw_tx(ctx context.Context, raw string, block_number int) bool {
+	data, err := decode_hex(raw)
+	if err != nil {
+		log.Fatal("Invalid raw transaction: ", err)
+	}
+	txn, err := types.UnmarshalTransactionFromBinary(data)
+	if err != nil {
+		log.Fatal("Invalid raw transaction: ", err)
+	}
+
+	var (
+		header   *types.Header
+		reader   state.StateReader
+		get_hash func(uint64) common.Hash
+	)
+
+	switch {
+	case *RPC_URL != "":
+		if block_number < 1 {
+			log.Fatal("Genesis block has no parent state")
+		}
+		client := new_rpc_client(*RPC_URL, *RPC_CACHE)
+		block, err := client.block_by_number(uint64(block_number))
+		if err != nil {
+			log.Fatal(err)
+		}
+		header = block.Header()
+		reader = new_rpc_reader(client, uint64(block_number-1))
+		get_hash = client.get_hash()
+
+	case *PRESTATE != "":
+		pre, err := read_prestate(*PRESTATE)
+		if err != nil {
+			log.Fatal(err)
+		}
+		header = &types.Header{Number: big.NewInt(int64(block_number)), Difficulty: new(big.Int), BaseFee: new(big.Int)}
+		reader = new_alloc_reader(pre.alloc())
+		get_hash = func(uint64) common.Hash { return common.Hash{} }
+
+	default:
+		db := mdbx.NewMDBX(log_.New()).Path(*CHAINDATA_PATH).MustOpen()
+		defer db.Close()
+		tx, err := db.BeginRo(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer tx.Rollback()
+
+		if header = rawdb.ReadHeaderByNumber(tx, uint64(block_number)); header == nil {
+			log.Fatalf("Block %d not found\n", block_number)
+		}
+		reader = state.NewPlainState(tx, uint64(block_number))
+		get_hash = canonical_hash(tx)
+	}
+
+	block := types.NewBlock(header, []types.Transaction{txn}, nil, nil)
+	chainCfg, _, _ := tests.GetChainConfig("London")
+
+	results := analize(ctx, block, state.New(reader), chainCfg, get_hash)
+	hash := txn.Hash()
+	print_evm_result(results[0], &hash)
+	if *WHY != "" {
+		print_provenance(block.NumberU64(), results, nil)
+	}
+	return results[0].result
+}
+
+// analyses the -code given on the command line; with -graphviz the CFG dot
+// file is written as well
+func analize_code(ctx context.Context) bool {
+	code, err := read_code(*CODE)
+	if err != nil {
+		log.Fatal("Invalid code: ", err)
+	}
+	input, err := decode_hex(*CODE_INPUT)
+	if err != nil {
+		log.Fatal("Invalid input: ", err)
+	}
+	v, err := parse_quantity([]byte(*CODE_VALUE))
+	if err != nil {
+		log.Fatal("Invalid value: ", *CODE_VALUE)
+	}
+	value, overflow := uint256.FromBig(v)
+	if overflow {
+		log.Fatal("Invalid value: ", *CODE_VALUE)
+	}
+	caller, err := parse_address(*CODE_CALLER)
+	if err != nil {
+		log.Fatal("Invalid caller: ", err)
+	}
+	address, err := parse_address(*CODE_ADDRESS)
+	if err != nil {
+		log.Fatal("Invalid address: ", err)
+	}
+
+	r := code_run{
+		code:    code,
+		input:   input,
+		caller:  caller,
+		address: address,
+		value:   value,
+		pre:     make(prestate),
+	}
+	if *BLOCK_INDEX > 0 {
+		r.number = uint64(*BLOCK_INDEX)
+	}
+	if *PRESTATE != "" {
+		if r.pre, err = read_prestate(*PRESTATE); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	_evm := r.prepare()(ctx)
+
+	print_evm_result(_evm, nil)
+	if *WHY != "" {
+		print_provenance(r.number, []*evm{_evm}, nil)
+	}
+	return _evm.result
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/racytech/abs_evm/asm"
+)
+
+func TestRunCode(t *testing.T) {
+	address := common.HexToAddress("0xc0de")
+	other := common.HexToAddress("0x0a")
+
+	_evm := run_code(code_run{
+		code:    asm.MustAssemble("PUSH1 0x0a BALANCE POP PUSH1 1 PUSH1 0 SSTORE STOP"),
+		address: address,
+		pre:     make(prestate),
+	})
+	if !_evm.result || _evm.fail_reason != "" {
+		t.Fatalf("analysis failed: %s", _evm.fail_reason)
+	}
+	if !_evm.rw_set.has(other, READ) {
+		t.Errorf("balance of %s was not read", other)
+	}
+	if !_evm.rw_set.has(address, WRITE) {
+		t.Errorf("storage of %s was not written", address)
+	}
+
+	_evm = run_code(code_run{code: asm.MustAssemble("PUSH1 1 ADD"), address: address, pre: make(prestate)})
+	if _evm.result || _evm.fail_reason != "stack underflow" {
+		t.Errorf("result %t, reason %q, want stack underflow", _evm.result, _evm.fail_reason)
+	}
+}
+
+func TestReturnData(t *testing.T) {
+	// 0x0b returns the 5 bytes 0x0000000c0d; their size and the copied
+	// word are read as addresses
+	callee := asm.MustAssemble("PUSH5 0x0c0d PUSH1 0 MSTORE PUSH1 5 PUSH1 27 RETURN")
+	_evm := run_code(code_run{
+		code: asm.MustAssemble(`
+			PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0x0b GAS CALL POP
+			RETURNDATASIZE BALANCE POP
+			PUSH1 5 PUSH1 0 PUSH1 27 RETURNDATACOPY PUSH1 0 MLOAD BALANCE POP
+			STOP`),
+		address: common.HexToAddress("0x0a"),
+		pre:     prestate{common.HexToAddress("0x0b"): &prestate_account{Code: callee}},
+	})
+	if !_evm.result {
+		t.Fatalf("analysis failed: %s", _evm.fail_reason)
+	}
+	for _, addr := range []string{"0x05", "0x0c0d"} {
+		if !_evm.rw_set.has(common.HexToAddress(addr), READ) {
+			t.Errorf("balance of %s was not read", addr)
+		}
+	}
+}
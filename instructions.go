@@ -1,64 +1,52 @@
 package main
 
 import (
-	"encoding/hex"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
 
 	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/erigon/common"
+	"github.com/racytech/abs_evm/asm"
 	"golang.org/x/crypto/sha3"
 )
 
+// set by the trace command
 var (
-	PRINT_FLAG          = false
-	SYSTEM_INSTRACTIONS = true
+	TRACING     = false
+	TRACE_CALLS = false // calls and creates only
+	TRACE_STACK = false
+	TRACE_START uint64
+	TRACE_STOP  uint64 = math.MaxUint64
 
-	CALL_ID         = -1
-	CALLCODE_ID     = -1
-	DELEGATECALL_ID = -1
-	STATICCALL_ID   = -1
-
-	CREATE_ID  = -1
-	CREATE2_ID = -1
-
-	PRINT_STACK bool   = false
-	START_PC    uint64 = 155
-	STOP_PC     uint64 = 186
-)
-
-const (
-	GAS_CONST    uint64 = 100_000_000_000
-	MAX_MEM_SIZE uint64 = 100_000
+	trace_out io.Writer = os.Stdout
 )
 
-func _print(s string) {
-	if PRINT_FLAG {
-		fmt.Println(s)
-	}
-
-	if SYSTEM_INSTRACTIONS && !PRINT_FLAG {
-		if s == "CREATE" || s == "CREATE2" || s == "CALL" || s == "CALLCODE" || s == "DELEGATECALL" || s == "STATICCALL" {
-			fmt.Println("------- ", s, " -------")
+// prints the instruction at pc before it runs: frame depth, address, pc,
+// instruction and with TRACE_STACK the stack, top last
+func trace(evm *evm, code []byte, pc uint64, ctx *callCtx) {
+	if pc < TRACE_START || pc > TRACE_STOP {
+		return
+	}
+	ins := asm.Next(code, pc)
+	if TRACE_CALLS {
+		switch ins.Op {
+		case CALL, CALLCODE, DELEGATECALL, STATICCALL, CREATE, CREATE2:
+		default:
+			return
 		}
 	}
-}
-
-func print_stack(pc *uint64, ctx *callCtx) {
-	if PRINT_STACK && *pc >= START_PC && *pc <= STOP_PC {
-		bytecode := ctx.contract.Code
-		op := bytecode[*pc]
-		if val, ok := __OPCODES[op]; ok {
-			fmt.Printf("---------------- pc: %d %s ----------------\n", *pc, val)
-		} else {
-			if op >= 0x60 && op <= 0x7f {
-				takes := op - 0x5F
-				bytes := hex.EncodeToString(bytecode[*pc+1 : *pc+1+uint64(takes)])
-				fmt.Printf("---------------- pc: %d  %s%d %v ----------------\n", *pc, "PUSH", takes, bytes)
-			}
+	line := fmt.Sprintf("%d %s %5d %s", evm.level, ctx.contract.Address().Hex(), pc, ins)
+	if TRACE_STACK {
+		words := make([]string, len(ctx.stack.Data))
+		for i := range ctx.stack.Data {
+			words[i] = ctx.stack.Data[i].Hex()
 		}
-
-		// ctx.stack.Print()
+		line = fmt.Sprintf("%-72s [%s]", line, strings.Join(words, " "))
 	}
+	fmt.Fprintln(trace_out, line)
 }
 
 /* -------------- 0s: Stop and Arithmetic Operations -------------- */
@@ -330,7 +318,7 @@ func op_BALANCE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 
 	slot.Set(balance)
 
-	in.evm.rw_set.add(address, READ)
+	in.evm.access(*pc, BALANCE, address, READ)
 
 	return 0
 }
@@ -420,7 +408,7 @@ func op_EXTCODESIZE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	slot.SetUint64(uint64(in.evm.state.GetCodeSize(address)))
 
 	// report access points
-	in.evm.rw_set.add(address, READ)
+	in.evm.access(*pc, EXTCODESIZE, address, READ)
 
 	return 0
 }
@@ -439,23 +427,20 @@ func op_EXTCODECOPY(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	ctx.memory.Set(mem_offset.Uint64(), size64, codeCopy)
 
 	// report access points
-	in.evm.rw_set.add(address, READ)
+	in.evm.access(*pc, EXTCODECOPY, address, READ)
 
 	return 0
 }
 
 func op_RETURNDATASIZE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
-	// TODO
-	ctx.stack.Push(new(uint256.Int).SetUint64(uint64(32)))
+	ctx.stack.Push(new(uint256.Int).SetUint64(uint64(len(in.evm.last_return_data()))))
 	return 0
 }
 
 func op_RETURNDATACOPY(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
-	for i := 0; i < 3; i++ {
-		ctx.stack.Pop()
-	}
-	// TODO
-
+	mem_offset, data_offset, length := ctx.stack.Pop(), ctx.stack.Pop(), ctx.stack.Pop()
+	data := getDataBig(in.evm.last_return_data(), &data_offset, length.Uint64())
+	ctx.memory.Set(mem_offset.Uint64(), length.Uint64(), data)
 	return 0
 }
 
@@ -470,7 +455,7 @@ func op_EXTCODEHASH(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	}
 
 	// report access points
-	in.evm.rw_set.add(address, READ)
+	in.evm.access(*pc, EXTCODEHASH, address, READ)
 
 	return 0
 }
@@ -491,7 +476,7 @@ func op_BLOCKHASH(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 		lower = upper - 256
 	}
 	if num64 >= lower && num64 < upper {
-		num.SetBytes(in.evm.block.Hash().Bytes())
+		num.SetBytes(in.evm.get_hash(num64).Bytes())
 	} else {
 		num.Clear()
 	}
@@ -547,6 +532,11 @@ func op_SELFBALANCE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	return 0
 }
 
+func op_BASEFEE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
+	ctx.stack.Push(in.evm.base_fee())
+	return 0
+}
+
 /* ----- 50s: Stack, Memory, Storage and Flow Operations ----- */
 
 func op_POP(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
@@ -569,7 +559,7 @@ func op_MSTORE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 
 func op_MSTORE8(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	off, val := ctx.stack.Pop(), ctx.stack.Pop()
-	ctx.memory.store[off.Uint64()] = byte(val.Uint64())
+	ctx.memory.SetByte(off.Uint64(), byte(val.Uint64()))
 	return 0
 }
 
@@ -583,7 +573,7 @@ func op_SLOAD(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 		in.evm.state.GetState(addr, &in.hasherBuf, loc)
 	}
 	// report access points
-	in.evm.rw_set.add(ctx.contract.Address(), READ)
+	in.evm.access(*pc, SLOAD, ctx.contract.Address(), READ)
 	return 0
 }
 
@@ -597,7 +587,7 @@ func op_SSTORE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	in.evm.mstate.set_state(addr, &in.hasherBuf, val)
 
 	// report access points
-	in.evm.rw_set.add(ctx.contract.Address(), WRITE)
+	in.evm.access(*pc, SSTORE, ctx.contract.Address(), WRITE)
 	return 0
 }
 
@@ -631,7 +621,7 @@ func op_MSIZE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 
 func op_GAS(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	// fmt.Println("GAS")
-	ctx.stack.Push(new(uint256.Int).SetUint64(GAS_CONST))
+	ctx.stack.Push(new(uint256.Int).SetUint64(in.evm.limits.Gas))
 	return 0
 }
 
@@ -694,8 +684,7 @@ func op_CALL(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	if bytes_size > 1 {
 		// we have many possible returns from previous execution
 		// so we dont know what is exact response
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_MANY)
 		return 0
 	} else if bytes_size == 1 {
 		// we have exactly one return from previous execution
@@ -705,8 +694,7 @@ func op_CALL(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 		return 0
 	} else {
 		// we have no returns from previous execution
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_NONE)
 		return 0
 	}
 }
@@ -730,8 +718,7 @@ func op_CALLCODE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	if bytes_size > 1 {
 		// we have many possible returns from previous execution
 		// so we dont know what is exact response
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_MANY)
 		return 0
 	} else if bytes_size == 1 {
 		// we have exactly one return from previous execution
@@ -741,8 +728,7 @@ func op_CALLCODE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 		return 0
 	} else {
 		// we have no returns from previous execution
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_NONE)
 		return 0
 	}
 }
@@ -766,8 +752,7 @@ func op_DELEGATECALL(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	if bytes_size > 1 {
 		// we have many possible returns from previous execution
 		// so we dont know what is exact response
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_MANY)
 		return 0
 	} else if bytes_size == 1 {
 		// we have exactly one return from previous execution
@@ -777,8 +762,7 @@ func op_DELEGATECALL(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 		return 0
 	} else {
 		// we have no returns from previous execution
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_NONE)
 		return 0
 	}
 }
@@ -802,8 +786,7 @@ func op_STATICCALL(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	if bytes_size > 1 {
 		// we have many possible returns from previous execution
 		// so we dont know what is exact response
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_MANY)
 		return 0
 	} else if bytes_size == 1 {
 		// we have exactly one return from previous execution
@@ -813,8 +796,7 @@ func op_STATICCALL(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 		return 0
 	} else {
 		// we have no returns from previous execution
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_NONE)
 		return 0
 	}
 }
@@ -857,9 +839,9 @@ func op_SELFDESTRUCT(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	in.evm.mstate.add_balance(beneficiaryAddr, balance)
 
 	// report access points
-	in.evm.rw_set.add(callerAddr, READ)
+	in.evm.access(*pc, SELFDESTRUCT, callerAddr, READ)
 	// repost possible suicide
-	in.evm.suicide = true
+	in.evm.self_destruct()
 
 	return 0
 }
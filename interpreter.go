@@ -43,13 +43,17 @@ type callCtx struct {
 	contract *Contract
 }
 
+// copy forks the context for a branch, both sides may run on without
+// seeing each other's writes
 func (ctx *callCtx) copy() *callCtx {
 	new_ctx := &callCtx{
 		memory:   ctx.memory._copy(),
 		stack:    ctx.stack._copy(),
-		p_stack:  ctx.p_stack,
 		contract: ctx.contract,
 	}
+	if ctx.p_stack != nil {
+		new_ctx.p_stack = ctx.p_stack._copy()
+	}
 
 	return new_ctx
 }
@@ -75,10 +79,14 @@ func (in *interpreter) g_run(pc *uint64, callCtx *callCtx) (uint64, bool) {
 
 	l := uint64(len(bytecode))
 
-	for *pc < l {
+	for *pc < l && !in.evm.abort && in.evm.step() {
 
 		op := bytecode[*pc]
 		operation := in.g_jt[op]
+		if TRACING {
+			trace(in.evm, bytecode, *pc, callCtx)
+		}
+		in.evm.pc = *pc
 
 		if operation == nil || op == INVALID {
 			// invalid operation
@@ -108,12 +116,15 @@ func (in *interpreter) g_run(pc *uint64, callCtx *callCtx) (uint64, bool) {
 		}
 
 		if memory_size > 0 {
-			if memory_size > GAS_CONST {
+			if memory_size > in.evm.limits.Gas {
 				return GAS_CONST_ERR, false
 			}
-			if memory_size > MAX_MEM_SIZE {
+			if memory_size > in.evm.limits.FrameMemory {
 				return TOO_LARGE_MEM_ERR, false
 			}
+			if !in.evm.grow(memory, memory_size) {
+				return END_OF_LOOP, false
+			}
 			memory.Resize(memory_size)
 		}
 
@@ -138,10 +149,14 @@ func (in *interpreter) run(pc *uint64, ctx *callCtx, bytecode *[]byte, code_size
 
 	stack := ctx.stack
 
-	for *pc < *code_size && !in.evm.abort {
+	for *pc < *code_size && !in.evm.abort && in.evm.step() {
 
 		op := (*bytecode)[*pc]
 		operation := in.jt[op]
+		in.evm.pc = *pc
+		if TRACING {
+			trace(in.evm, *bytecode, *pc, ctx)
+		}
 
 		if operation == nil || op == INVALID {
 			return INVALID_OP, false
@@ -166,12 +181,15 @@ func (in *interpreter) run(pc *uint64, ctx *callCtx, bytecode *[]byte, code_size
 		}
 
 		if memory_size > 0 {
-			if memory_size > GAS_CONST {
+			if memory_size > in.evm.limits.Gas {
 				return GAS_CONST_ERR, false
 			}
-			if memory_size > MAX_MEM_SIZE {
+			if memory_size > in.evm.limits.FrameMemory {
 				return TOO_LARGE_MEM_ERR, false
 			}
+			if !in.evm.grow(ctx.memory, memory_size) {
+				return END_OF_LOOP, false
+			}
 			ctx.memory.Resize(memory_size)
 		}
 
@@ -195,9 +213,13 @@ func (in *interpreter) run(pc *uint64, ctx *callCtx, bytecode *[]byte, code_size
 
 func (in *interpreter) lp_run(pc *uint64, ctx *callCtx, bytecode *[]byte, code_size *uint64) uint64 {
 	stack := ctx.stack
-	for *pc < *code_size {
+	for *pc < *code_size && !in.evm.abort && in.evm.step() {
 		op := (*bytecode)[*pc]
 		operation := in.lp_jt[op]
+		in.evm.pc = *pc
+		if TRACING {
+			trace(in.evm, *bytecode, *pc, ctx)
+		}
 
 		if operation == nil || op == INVALID {
 			return INVALID_OP
@@ -222,12 +244,15 @@ func (in *interpreter) lp_run(pc *uint64, ctx *callCtx, bytecode *[]byte, code_s
 		}
 
 		if memory_size > 0 {
-			if memory_size > GAS_CONST {
+			if memory_size > in.evm.limits.Gas {
 				return GAS_CONST_ERR
 			}
-			if memory_size > MAX_MEM_SIZE {
+			if memory_size > in.evm.limits.FrameMemory {
 				return TOO_LARGE_MEM_ERR
 			}
+			if !in.evm.grow(ctx.memory, memory_size) {
+				return END_OF_LOOP
+			}
 			ctx.memory.Resize(memory_size)
 		}
 
@@ -42,8 +42,8 @@ func new_dup_op(exec exec_func, n int) *operation {
 		execute:   exec,
 		min_stack: minDupStack(n),
 		max_stack: maxDupStack(n),
-		num_pop:   0,
-		num_push:  1,
+		num_pop:   n,
+		num_push:  n + 1,
 	}
 }
 
@@ -52,8 +52,8 @@ func new_swap_op(exec exec_func, n int) *operation {
 		execute:   exec,
 		min_stack: minSwapStack(n),
 		max_stack: maxSwapStack(n),
-		num_pop:   1,
-		num_push:  1,
+		num_pop:   n,
+		num_push:  n,
 	}
 }
 
@@ -113,14 +113,14 @@ func new_jt() jump_table {
 		AND:    new_op(op_AND, 2, 1),
 		OR:     new_op(op_OR, 2, 1),
 		XOR:    new_op(op_XOR, 2, 1),
-		NOT:    new_op(op_NOT, 2, 1),
+		NOT:    new_op(op_NOT, 1, 1),
 		BYTE:   new_op(op_BYTE, 2, 1),
 		SHL:    new_op(op_SHL, 2, 1),
 		SHR:    new_op(op_SHR, 2, 1),
 		SAR:    new_op(op_SAR, 2, 1),
 
 		/* 20s: SHA3 */
-		SHA3: new_op(op_SAR, 2, 1).with_mem(memorySha3),
+		SHA3: new_op(op_SHA3, 2, 1).with_mem(memorySha3),
 
 		/* 30s: Environmental Information */
 		ADDRESS:        new_op(op_ADDRESS, 0, 1),
@@ -149,6 +149,7 @@ func new_jt() jump_table {
 		GASLIMIT:    new_op(op_GASLIMIT, 0, 1),
 		CHAINID:     new_op(op_CHAINID, 0, 1),
 		SELFBALANCE: new_op(op_SELFBALANCE, 0, 1),
+		BASEFEE:     new_op(op_BASEFEE, 0, 1),
 
 		/* 50s: Stack, Memory, Storage and Flow Operations */
 		POP:      new_op(op_POP, 1, 0),
@@ -249,7 +250,8 @@ func new_jt() jump_table {
 		RETURN:       new_op(op_RETURN, 2, 0).with_mem(memoryReturn)._halts(),
 		DELEGATECALL: new_op(op_DELEGATECALL, 6, 1).with_mem(memoryDelegateCall)._returns(),
 
-		CREATE2: new_op(op_CREATE2, 4, 1).with_mem(memoryCreate2)._writes()._returns(),
+		CREATE2:    new_op(op_CREATE2, 4, 1).with_mem(memoryCreate2)._writes()._returns(),
+		STATICCALL: new_op(op_STATICCALL, 6, 1).with_mem(memoryStaticCall)._returns(),
 
 		REVERT: new_op(op_REVERT, 2, 0).with_mem(memoryRevert)._reverts()._returns(),
 
@@ -284,14 +286,14 @@ func new_graph_jt() jump_table {
 		AND:    new_op(g_AND, 2, 1),
 		OR:     new_op(g_OR, 2, 1),
 		XOR:    new_op(g_XOR, 2, 1),
-		NOT:    new_op(g_NOT, 2, 1),
+		NOT:    new_op(g_NOT, 1, 1),
 		BYTE:   new_op(g_BYTE, 2, 1),
 		SHL:    new_op(g_SHL, 2, 1),
 		SHR:    new_op(g_SHR, 2, 1),
 		SAR:    new_op(g_SAR, 2, 1),
 
 		/* 20s: SHA3 */
-		SHA3: new_op(g_SAR, 2, 1).with_mem(memorySha3),
+		SHA3: new_op(g_SHA3, 2, 1).with_mem(memorySha3),
 
 		/* 30s: Environmental Information */
 		ADDRESS:        new_op(g_ADDRESS, 0, 1),
@@ -320,6 +322,7 @@ func new_graph_jt() jump_table {
 		GASLIMIT:    new_op(g_GASLIMIT, 0, 1),
 		CHAINID:     new_op(g_CHAINID, 0, 1),
 		SELFBALANCE: new_op(g_SELFBALANCE, 0, 1),
+		BASEFEE:     new_op(g_BASEFEE, 0, 1),
 
 		/* 50s: Stack, Memory, Storage and Flow Operations */
 		POP:      new_op(g_POP, 1, 0),
@@ -420,7 +423,8 @@ func new_graph_jt() jump_table {
 		RETURN:       new_op(g_RETURN, 2, 0).with_mem(memoryReturn)._halts(),
 		DELEGATECALL: new_op(g_DELEGATECALL, 6, 1).with_mem(memoryDelegateCall)._returns(),
 
-		CREATE2: new_op(g_CREATE2, 4, 1).with_mem(memoryCreate2)._writes()._returns(),
+		CREATE2:    new_op(g_CREATE2, 4, 1).with_mem(memoryCreate2)._writes()._returns(),
+		STATICCALL: new_op(g_STATICCALL, 6, 1).with_mem(memoryStaticCall)._returns(),
 
 		REVERT: new_op(g_REVERT, 2, 0).with_mem(memoryRevert)._reverts()._returns(),
 
@@ -455,14 +459,14 @@ func new_loop_jt() jump_table {
 		AND:    new_op(lp_AND, 2, 1),
 		OR:     new_op(lp_OR, 2, 1),
 		XOR:    new_op(lp_XOR, 2, 1),
-		NOT:    new_op(lp_NOT, 2, 1),
+		NOT:    new_op(lp_NOT, 1, 1),
 		BYTE:   new_op(lp_BYTE, 2, 1),
 		SHL:    new_op(lp_SHL, 2, 1),
 		SHR:    new_op(lp_SHR, 2, 1),
 		SAR:    new_op(lp_SAR, 2, 1),
 
 		/* 20s: SHA3 */
-		SHA3: new_op(lp_SAR, 2, 1).with_mem(memorySha3),
+		SHA3: new_op(lp_SHA3, 2, 1).with_mem(memorySha3),
 
 		/* 30s: Environmental Information */
 		ADDRESS:        new_op(lp_ADDRESS, 0, 1),
@@ -491,6 +495,7 @@ func new_loop_jt() jump_table {
 		GASLIMIT:    new_op(lp_GASLIMIT, 0, 1),
 		CHAINID:     new_op(lp_CHAINID, 0, 1),
 		SELFBALANCE: new_op(lp_SELFBALANCE, 0, 1),
+		BASEFEE:     new_op(lp_BASEFEE, 0, 1),
 
 		/* 50s: Stack, Memory, Storage and Flow Operations */
 		POP:      new_op(lp_POP, 1, 0),
@@ -591,7 +596,8 @@ func new_loop_jt() jump_table {
 		RETURN:       new_op(lp_RETURN, 2, 0).with_mem(memoryReturn)._halts(),
 		DELEGATECALL: new_op(lp_DELEGATECALL, 6, 1).with_mem(memoryDelegateCall)._returns(),
 
-		CREATE2: new_op(lp_CREATE2, 4, 1).with_mem(memoryCreate2)._writes()._returns(),
+		CREATE2:    new_op(lp_CREATE2, 4, 1).with_mem(memoryCreate2)._writes()._returns(),
+		STATICCALL: new_op(lp_STATICCALL, 6, 1).with_mem(memoryStaticCall)._returns(),
 
 		REVERT: new_op(lp_REVERT, 2, 0).with_mem(memoryRevert)._reverts()._returns(),
 
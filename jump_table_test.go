@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+	"github.com/ledgerwatch/erigon/common/math"
+	"github.com/ledgerwatch/erigon/core"
+	"github.com/ledgerwatch/erigon/core/state"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+	"github.com/ledgerwatch/erigon/core/vm"
+	"github.com/ledgerwatch/erigon/core/vm/stack"
+	"github.com/ledgerwatch/erigon/params"
+	"github.com/ledgerwatch/erigon/tests"
+)
+
+// expected behaviour of a single opcode, taken from the yellow paper
+// (appendix H): δ items removed from the stack, α items added
+type op_spec struct {
+	name    string
+	pops    int
+	pushes  int
+	mem     mem_size_func
+	halts   bool
+	jumps   bool
+	reverts bool
+}
+
+func spec(name string, pops, pushes int) op_spec {
+	return op_spec{name: name, pops: pops, pushes: pushes}
+}
+
+func (s op_spec) with_mem(mem mem_size_func) op_spec {
+	s.mem = mem
+	return s
+}
+
+func (s op_spec) _halts() op_spec {
+	s.halts = true
+	return s
+}
+
+func (s op_spec) _jumps() op_spec {
+	s.jumps = true
+	return s
+}
+
+func (s op_spec) _reverts() op_spec {
+	s.reverts = true
+	return s
+}
+
+// London instruction set
+func london_spec() map[byte]op_spec {
+	specs := map[byte]op_spec{
+		STOP:       spec("STOP", 0, 0)._halts(),
+		ADD:        spec("ADD", 2, 1),
+		MUL:        spec("MUL", 2, 1),
+		SUB:        spec("SUB", 2, 1),
+		DIV:        spec("DIV", 2, 1),
+		SDIV:       spec("SDIV", 2, 1),
+		MOD:        spec("MOD", 2, 1),
+		SMOD:       spec("SMOD", 2, 1),
+		ADDMOD:     spec("ADDMOD", 3, 1),
+		MULMOD:     spec("MULMOD", 3, 1),
+		EXP:        spec("EXP", 2, 1),
+		SIGNEXTEND: spec("SIGNEXTEND", 2, 1),
+
+		LT:     spec("LT", 2, 1),
+		GT:     spec("GT", 2, 1),
+		SLT:    spec("SLT", 2, 1),
+		SGT:    spec("SGT", 2, 1),
+		EQ:     spec("EQ", 2, 1),
+		ISZERO: spec("ISZERO", 1, 1),
+		AND:    spec("AND", 2, 1),
+		OR:     spec("OR", 2, 1),
+		XOR:    spec("XOR", 2, 1),
+		NOT:    spec("NOT", 1, 1),
+		BYTE:   spec("BYTE", 2, 1),
+		SHL:    spec("SHL", 2, 1),
+		SHR:    spec("SHR", 2, 1),
+		SAR:    spec("SAR", 2, 1),
+
+		SHA3: spec("SHA3", 2, 1).with_mem(memorySha3),
+
+		ADDRESS:        spec("ADDRESS", 0, 1),
+		BALANCE:        spec("BALANCE", 1, 1),
+		ORIGIN:         spec("ORIGIN", 0, 1),
+		CALLER:         spec("CALLER", 0, 1),
+		CALLVALUE:      spec("CALLVALUE", 0, 1),
+		CALLDATALOAD:   spec("CALLDATALOAD", 1, 1),
+		CALLDATASIZE:   spec("CALLDATASIZE", 0, 1),
+		CALLDATACOPY:   spec("CALLDATACOPY", 3, 0).with_mem(memoryCallDataCopy),
+		CODESIZE:       spec("CODESIZE", 0, 1),
+		CODECOPY:       spec("CODECOPY", 3, 0).with_mem(memoryCodeCopy),
+		GASPRICE:       spec("GASPRICE", 0, 1),
+		EXTCODESIZE:    spec("EXTCODESIZE", 1, 1),
+		EXTCODECOPY:    spec("EXTCODECOPY", 4, 0).with_mem(memoryExtCodeCopy),
+		RETURNDATASIZE: spec("RETURNDATASIZE", 0, 1),
+		RETURNDATACOPY: spec("RETURNDATACOPY", 3, 0).with_mem(memoryReturnDataCopy),
+		EXTCODEHASH:    spec("EXTCODEHASH", 1, 1),
+
+		BLOCKHASH:   spec("BLOCKHASH", 1, 1),
+		COINBASE:    spec("COINBASE", 0, 1),
+		TIMESTAMP:   spec("TIMESTAMP", 0, 1),
+		NUMBER:      spec("NUMBER", 0, 1),
+		DIFFICULTY:  spec("DIFFICULTY", 0, 1),
+		GASLIMIT:    spec("GASLIMIT", 0, 1),
+		CHAINID:     spec("CHAINID", 0, 1),
+		SELFBALANCE: spec("SELFBALANCE", 0, 1),
+		BASEFEE:     spec("BASEFEE", 0, 1),
+
+		POP:      spec("POP", 1, 0),
+		MLOAD:    spec("MLOAD", 1, 1).with_mem(memoryMLoad),
+		MSTORE:   spec("MSTORE", 2, 0).with_mem(memoryMStore),
+		MSTORE8:  spec("MSTORE8", 2, 0).with_mem(memoryMStore8),
+		SLOAD:    spec("SLOAD", 1, 1),
+		SSTORE:   spec("SSTORE", 2, 0),
+		JUMP:     spec("JUMP", 1, 0)._jumps(),
+		JUMPI:    spec("JUMPI", 2, 0)._jumps(),
+		PC:       spec("PC", 0, 1),
+		MSIZE:    spec("MSIZE", 0, 1),
+		GAS:      spec("GAS", 0, 1),
+		JUMPDEST: spec("JUMPDEST", 0, 0),
+
+		CREATE:       spec("CREATE", 3, 1).with_mem(memoryCreate),
+		CALL:         spec("CALL", 7, 1).with_mem(memoryCall),
+		CALLCODE:     spec("CALLCODE", 7, 1).with_mem(memoryCall),
+		RETURN:       spec("RETURN", 2, 0).with_mem(memoryReturn)._halts(),
+		DELEGATECALL: spec("DELEGATECALL", 6, 1).with_mem(memoryDelegateCall),
+		CREATE2:      spec("CREATE2", 4, 1).with_mem(memoryCreate2),
+		STATICCALL:   spec("STATICCALL", 6, 1).with_mem(memoryStaticCall),
+		REVERT:       spec("REVERT", 2, 0).with_mem(memoryRevert)._reverts(),
+		INVALID:      spec("INVALID", 0, 0),
+		SELFDESTRUCT: spec("SELFDESTRUCT", 1, 0)._halts(),
+	}
+
+	for i := 1; i <= 32; i++ {
+		specs[PUSH1+byte(i-1)] = spec(fmt.Sprintf("PUSH%d", i), 0, 1)
+	}
+	for i := 1; i <= 16; i++ {
+		specs[DUP1+byte(i-1)] = spec(fmt.Sprintf("DUP%d", i), i, i+1)
+		specs[SWAP1+byte(i-1)] = spec(fmt.Sprintf("SWAP%d", i), i+1, i+1)
+	}
+	for i := 0; i <= 4; i++ {
+		specs[LOG0+byte(i)] = spec(fmt.Sprintf("LOG%d", i), i+2, 0).with_mem(memoryLog)
+	}
+
+	return specs
+}
+
+// jump tables under test together with the interpreter loop that uses them
+type jt_case struct {
+	name string
+	jt   func(in *interpreter) *jump_table
+	run  func(in *interpreter, pc *uint64, ctx *callCtx) (uint64, bool)
+}
+
+var jt_cases = []jt_case{
+	{
+		name: "new_jt",
+		jt:   func(in *interpreter) *jump_table { return in.jt },
+		run: func(in *interpreter, pc *uint64, ctx *callCtx) (uint64, bool) {
+			size := uint64(len(ctx.contract.Code))
+			return in.run(pc, ctx, &ctx.contract.Code, &size)
+		},
+	},
+	{
+		name: "new_graph_jt",
+		jt:   func(in *interpreter) *jump_table { return in.g_jt },
+		run: func(in *interpreter, pc *uint64, ctx *callCtx) (uint64, bool) {
+			return in.g_run(pc, ctx)
+		},
+	},
+	{
+		name: "new_loop_jt",
+		jt:   func(in *interpreter) *jump_table { return in.lp_jt },
+		run: func(in *interpreter, pc *uint64, ctx *callCtx) (uint64, bool) {
+			size := uint64(len(ctx.contract.Code))
+			return in.lp_run(pc, ctx, &ctx.contract.Code, &size), false
+		},
+	},
+}
+
+func fn_ptr(f interface{}) uintptr {
+	v := reflect.ValueOf(f)
+	if v.IsNil() {
+		return 0
+	}
+	return v.Pointer()
+}
+
+func TestJumpTableConformance(t *testing.T) {
+	specs := london_spec()
+	_evm := new_test_evm(t, new_test_block(), state.New(empty_reader{}))
+
+	for _, c := range jt_cases {
+		jt := c.jt(_evm.interpreter)
+
+		// every opcode has its own implementation, except PUSH, DUP, SWAP
+		// and LOG, which are made by the same constructor
+		seen := make(map[uintptr]byte)
+		for i := 0; i < 256; i++ {
+			op := byte(i)
+			if jt[op] == nil || (op > PUSH1 && op <= SWAP16) || (op >= LOG0 && op <= LOG4) {
+				continue
+			}
+			ptr := fn_ptr(jt[op].execute)
+			if prev, ok := seen[ptr]; ok {
+				t.Errorf("%s: %s and %s share the same implementation", c.name, specs[prev].name, specs[op].name)
+			}
+			seen[ptr] = op
+		}
+
+		for i := 0; i < 256; i++ {
+			op := byte(i)
+			s, defined := specs[op]
+			operation := jt[op]
+
+			if !defined {
+				if operation != nil {
+					t.Errorf("%s: 0x%02x is not an opcode but has an entry", c.name, op)
+				}
+				continue
+			}
+			if operation == nil {
+				t.Errorf("%s: %s is missing", c.name, s.name)
+				continue
+			}
+
+			if operation.num_pop != s.pops || operation.num_push != s.pushes {
+				t.Errorf("%s: %s pops/pushes %d/%d, want %d/%d", c.name, s.name,
+					operation.num_pop, operation.num_push, s.pops, s.pushes)
+			}
+			if want := minStack(s.pops, s.pushes); operation.min_stack != want {
+				t.Errorf("%s: %s min_stack %d, want %d", c.name, s.name, operation.min_stack, want)
+			}
+			if want := maxStack(s.pops, s.pushes); operation.max_stack != want {
+				t.Errorf("%s: %s max_stack %d, want %d", c.name, s.name, operation.max_stack, want)
+			}
+			if fn_ptr(operation.mem_size) != fn_ptr(s.mem) {
+				t.Errorf("%s: %s has wrong memory expansion function", c.name, s.name)
+			}
+			if operation.halts != s.halts || operation.jumps != s.jumps || operation.reverts != s.reverts {
+				t.Errorf("%s: %s halts/jumps/reverts %t/%t/%t, want %t/%t/%t", c.name, s.name,
+					operation.halts, operation.jumps, operation.reverts, s.halts, s.jumps, s.reverts)
+			}
+		}
+	}
+}
+
+// executes every opcode in isolation and checks that the stack changed
+// by exactly α - δ items, and that the stack bounds are enforced
+func TestJumpTableStackEffects(t *testing.T) {
+	specs := london_spec()
+
+	ops := make([]int, 0, len(specs))
+	for op := range specs {
+		ops = append(ops, int(op))
+	}
+	sort.Ints(ops)
+
+	for _, c := range jt_cases {
+		for _, i := range ops {
+			op := byte(i)
+			s := specs[op]
+			if op == INVALID {
+				continue
+			}
+
+			code := []byte{op}
+			if op >= PUSH1 && op <= PUSH32 {
+				code = append(code, make([]byte, op-PUSH1+1)...)
+			}
+
+			_evm := new_test_evm(t, new_test_block(), state.New(empty_reader{}))
+			ctx := new_test_ctx(code, nil)
+			for j := 0; j < s.pops; j++ {
+				ctx.stack.Push(new(uint256.Int))
+			}
+
+			pc := uint64(0)
+			res, _ := c.run(_evm.interpreter, &pc, ctx)
+			if res == INVALID_OP || res == STACK_UNDERFLOW || res == STACK_OVERFLOW {
+				t.Errorf("%s: %s failed with 0x%x", c.name, s.name, res)
+				continue
+			}
+
+			// nested frames without a single return value abort the analysis,
+			// in that case nothing is pushed to the stack
+			if _evm.abort {
+				continue
+			}
+
+			if got, want := ctx.stack.Len(), s.pushes; got != want {
+				t.Errorf("%s: %s left %d items on the stack, want %d", c.name, s.name, got, want)
+			}
+
+			if s.pops > 0 {
+				ctx = new_test_ctx(code, nil)
+				for j := 0; j < s.pops-1; j++ {
+					ctx.stack.Push(new(uint256.Int))
+				}
+				pc = 0
+				if res, _ := c.run(_evm.interpreter, &pc, ctx); res != STACK_UNDERFLOW {
+					t.Errorf("%s: %s with %d items returned 0x%x, want stack underflow", c.name, s.name, s.pops-1, res)
+				}
+			}
+
+			ctx = new_test_ctx(code, nil)
+			for j := 0; j <= maxStack(s.pops, s.pushes); j++ {
+				ctx.stack.Push(new(uint256.Int))
+			}
+			pc = 0
+			if res, _ := c.run(_evm.interpreter, &pc, ctx); res != STACK_OVERFLOW {
+				t.Errorf("%s: %s with %d items returned 0x%x, want stack overflow", c.name, s.name, ctx.stack.Len(), res)
+			}
+		}
+	}
+}
+
+/* ---------------- VMTests ---------------- */
+
+// testdata/VMTests holds local, hand-written vectors in the layout of the
+// ethereum/tests VMTests (a subset of it), not the upstream suite:
+// https://ethereum-tests.readthedocs.io/en/latest/test_types/vm_tests.html
+// The EVM of erigon checks each vector first (post storage, gas left and
+// output), then every interpreter loop has to leave the same storage and
+// stack as it.
+type vm_test struct {
+	Env struct {
+		Coinbase   common.Address        `json:"currentCoinbase"`
+		Difficulty *math.HexOrDecimal256 `json:"currentDifficulty"`
+		GasLimit   math.HexOrDecimal64   `json:"currentGasLimit"`
+		Number     math.HexOrDecimal64   `json:"currentNumber"`
+		Timestamp  math.HexOrDecimal64   `json:"currentTimestamp"`
+		BaseFee    *math.HexOrDecimal256 `json:"currentBaseFee"`
+	} `json:"env"`
+	Exec struct {
+		Address  common.Address        `json:"address"`
+		Caller   common.Address        `json:"caller"`
+		Origin   common.Address        `json:"origin"`
+		Code     hexutil.Bytes         `json:"code"`
+		Data     hexutil.Bytes         `json:"data"`
+		Value    *math.HexOrDecimal256 `json:"value"`
+		GasPrice *math.HexOrDecimal256 `json:"gasPrice"`
+		Gas      math.HexOrDecimal64   `json:"gas"`
+	} `json:"exec"`
+	Gas  math.HexOrDecimal64 `json:"gas"` // left after the code ran
+	Out  hexutil.Bytes       `json:"out"`
+	Pre  core.GenesisAlloc   `json:"pre"`
+	Post core.GenesisAlloc   `json:"post"`
+}
+
+func load_vm_tests(t *testing.T, pattern string) map[string]vm_test {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no test vectors found in %s", pattern)
+	}
+
+	result := make(map[string]vm_test)
+	for _, file := range files {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := make(map[string]vm_test)
+		if err := json.Unmarshal(raw, &tests); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		for name, test := range tests {
+			result[filepath.Base(file)+"/"+name] = test
+		}
+	}
+	return result
+}
+
+// VMTests only run straight-line code, so each interpreter loop
+// must execute the code from start to the end without jumping
+func TestVMTests(t *testing.T) {
+	for name, test := range load_vm_tests(t, filepath.Join("testdata", "VMTests", "*.json")) {
+		test := test
+		var want_stack []uint256.Int
+		if !t.Run("erigon/"+name, func(t *testing.T) { want_stack = run_vm_reference(t, &test) }) {
+			continue
+		}
+		for _, c := range jt_cases {
+			t.Run(c.name+"/"+name, func(t *testing.T) {
+				run_vm_test(t, c, &test, want_stack)
+			})
+		}
+	}
+}
+
+// the stack of the top frame before each instruction
+type stack_tracer struct {
+	ops   []vm.OpCode
+	stack []uint256.Int
+}
+
+func (s *stack_tracer) CaptureStart(int, common.Address, common.Address, bool, bool, vm.CallType, []byte, uint64, *big.Int, common.Hash) error {
+	return nil
+}
+func (s *stack_tracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, st *stack.Stack, rData []byte, contract *vm.Contract, depth int, err error) error {
+	if depth == 1 {
+		s.ops = append(s.ops, op)
+		s.stack = append(s.stack[:0], st.Data...)
+	}
+	return nil
+}
+func (s *stack_tracer) CaptureFault(*vm.EVM, uint64, vm.OpCode, uint64, uint64, *vm.Memory, *stack.Stack, *vm.Contract, int, error) error {
+	return nil
+}
+func (s *stack_tracer) CaptureEnd(int, []byte, uint64, time.Duration, error) error   { return nil }
+func (s *stack_tracer) CaptureSelfDestruct(common.Address, common.Address, *big.Int) {}
+func (s *stack_tracer) CaptureAccountRead(common.Address) error                      { return nil }
+func (s *stack_tracer) CaptureAccountWrite(common.Address) error                     { return nil }
+
+// runs the vector on the EVM of erigon with every fork active, returns the
+// stack the code stopped with
+func run_vm_reference(t *testing.T, test *vm_test) []uint256.Int {
+	chainCfg, _, _ := tests.GetChainConfig("London")
+	ibs := new_alloc_state(test.Pre)
+	value, _ := uint256.FromBig((*big.Int)(test.Exec.Value))
+	base_fee, _ := uint256.FromBig((*big.Int)(test.Env.BaseFee))
+	// the call transfers the value from the caller
+	ibs.AddBalance(test.Exec.Caller, value)
+
+	block := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash:     func(uint64) common.Hash { return common.Hash{} },
+		CheckTEVM:   func(common.Hash) (bool, error) { return false, nil },
+		Coinbase:    test.Env.Coinbase,
+		BlockNumber: uint64(test.Env.Number),
+		Time:        uint64(test.Env.Timestamp),
+		Difficulty:  (*big.Int)(test.Env.Difficulty),
+		GasLimit:    uint64(test.Env.GasLimit),
+		BaseFee:     base_fee,
+	}
+	// warm as at the start of a transaction
+	rules := chainCfg.Rules(block.BlockNumber)
+	ibs.PrepareAccessList(test.Exec.Origin, &test.Exec.Address, vm.ActivePrecompiles(rules), nil)
+
+	tracer := &stack_tracer{}
+	env := vm.NewEVM(block, vm.TxContext{Origin: test.Exec.Origin, GasPrice: (*big.Int)(test.Exec.GasPrice)}, ibs, chainCfg, vm.Config{Debug: true, Tracer: tracer})
+
+	out, gas, err := env.Call(vm.AccountRef(test.Exec.Caller), test.Exec.Address, test.Exec.Data, uint64(test.Exec.Gas), value, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gas != uint64(test.Gas) {
+		t.Errorf("gas left %d, want %d", gas, uint64(test.Gas))
+	}
+	if !bytes.Equal(out, test.Out) {
+		t.Errorf("out %x, want %x", out, []byte(test.Out))
+	}
+	check_vm_storage(t, test, func(addr common.Address, key *common.Hash, value *uint256.Int) {
+		ibs.GetState(addr, key, value)
+	})
+
+	if n := len(tracer.ops); n == 0 || tracer.ops[n-1] != vm.STOP {
+		t.Fatalf("the code does not end with STOP: %v", tracer.ops)
+	}
+	return tracer.stack
+}
+
+func check_vm_storage(t *testing.T, test *vm_test, get func(common.Address, *common.Hash, *uint256.Int)) {
+	t.Helper()
+	for addr, account := range test.Post {
+		for key, want := range account.Storage {
+			key := key
+			var got uint256.Int
+			get(addr, &key, &got)
+			if got.Bytes32() != want {
+				t.Errorf("storage %x at %x: got %x, want %x", addr, key, got.Bytes32(), want)
+			}
+		}
+	}
+}
+
+func TestBaseFeeBeforeLondon(t *testing.T) {
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Difficulty: new(big.Int)})
+	for _, c := range jt_cases {
+		_evm := new_test_evm(t, block, state.New(empty_reader{}))
+		ctx := new_test_ctx([]byte{BASEFEE, STOP}, nil)
+		pc := uint64(0)
+		c.run(_evm.interpreter, &pc, ctx)
+		if _evm.abort || ctx.stack.Len() != 1 || !ctx.stack.Peek().IsZero() {
+			t.Errorf("%s: BASEFEE without a base fee: stack %v, %s", c.name, ctx.stack.Data, _evm.fail_reason)
+		}
+	}
+}
+
+func run_vm_test(t *testing.T, c jt_case, test *vm_test, want_stack []uint256.Int) {
+	header := &types.Header{
+		Coinbase:   test.Env.Coinbase,
+		Difficulty: (*big.Int)(test.Env.Difficulty),
+		GasLimit:   uint64(test.Env.GasLimit),
+		Number:     new(big.Int).SetUint64(uint64(test.Env.Number)),
+		Time:       uint64(test.Env.Timestamp),
+		BaseFee:    (*big.Int)(test.Env.BaseFee),
+	}
+	ibs := new_alloc_state(test.Pre)
+
+	value, _ := uint256.FromBig((*big.Int)(test.Exec.Value))
+	gasprice, _ := uint256.FromBig((*big.Int)(test.Exec.GasPrice))
+	// value is transferred before the code is executed
+	ibs.AddBalance(test.Exec.Address, value)
+
+	msg := types.NewMessage(test.Exec.Origin, &test.Exec.Address, 0, value, 0,
+		gasprice, gasprice, gasprice, test.Exec.Data, nil, false)
+	chainCfg := *params.MainnetChainConfig
+	_evm := new_evm(context.Background(), types.NewBlockWithHeader(header), ibs, chainCfg, msg)
+
+	contract := new_contract(AccountRef(test.Exec.Caller), AccountRef(test.Exec.Address), value)
+	contract.set_call_code(&test.Exec.Address, ibs.GetCodeHash(test.Exec.Address), test.Exec.Code)
+	contract.Input = test.Exec.Data
+
+	ctx := &callCtx{memory: NewMemory(), stack: NewStack(), contract: contract}
+	pc := uint64(0)
+	res, is_jump := c.run(_evm.interpreter, &pc, ctx)
+	if is_jump || (res != HALTS && res != END_OF_LOOP) {
+		t.Fatalf("execution stopped at pc %d with 0x%x", pc, res)
+	}
+
+	check_vm_storage(t, test, func(addr common.Address, key *common.Hash, value *uint256.Int) {
+		if !_evm.mstate.get_state(addr, key, value) {
+			ibs.GetState(addr, key, value)
+		}
+	})
+	if len(ctx.stack.Data) != len(want_stack) || (len(want_stack) > 0 && !reflect.DeepEqual(ctx.stack.Data, want_stack)) {
+		t.Errorf("stack %v, want %v", ctx.stack.Data, want_stack)
+	}
+}
+
+/* ---------------- helpers ---------------- */
+
+// state reader of an empty state
+type empty_reader struct{}
+
+func (empty_reader) ReadAccountData(common.Address) (*accounts.Account, error) { return nil, nil }
+func (empty_reader) ReadAccountStorage(common.Address, uint64, *common.Hash) ([]byte, error) {
+	return nil, nil
+}
+func (empty_reader) ReadAccountCode(common.Address, uint64, common.Hash) ([]byte, error) {
+	return nil, nil
+}
+func (empty_reader) ReadAccountCodeSize(common.Address, uint64, common.Hash) (int, error) {
+	return 0, nil
+}
+func (empty_reader) ReadAccountIncarnation(common.Address) (uint64, error) { return 0, nil }
+
+func new_test_block() *types.Block {
+	return types.NewBlockWithHeader(&types.Header{
+		Number:     big.NewInt(1),
+		Difficulty: big.NewInt(0x020000),
+		GasLimit:   0x7fffffffffffffff,
+		BaseFee:    big.NewInt(7),
+	})
+}
+
+func new_test_evm(t *testing.T, block *types.Block, ibs *state.IntraBlockState) *evm {
+	t.Helper()
+	msg := types.NewMessage(common.Address{}, &common.Address{}, 0, new(uint256.Int), 0,
+		new(uint256.Int), new(uint256.Int), new(uint256.Int), nil, nil, false)
+	_evm := new_evm(context.Background(), block, ibs, *params.MainnetChainConfig, msg)
+	// frames are entered through evm.call, so level is never below zero
+	_evm.level = 0
+	return _evm
+}
+
+func new_test_ctx(code, input []byte) *callCtx {
+	addr := common.HexToAddress("0x636f6e7472616374")
+	contract := new_contract(AccountRef(common.Address{}), AccountRef(addr), new(uint256.Int))
+	contract.set_call_code(&addr, common.Hash{}, code)
+	contract.Input = input
+	return &callCtx{memory: NewMemory(), stack: &Stack{}, contract: contract}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// limits of the analysis, from the flags or -config. Reports record them,
+// a result is reproduced with the same limits.
+type limits struct {
+	Depth       int    `json:"depth"`       // nested exec frames below the transaction
+	LoopCycles  int    `json:"loopCycles"`  // cycles a loop may run before the analysis fails
+	Gas         uint64 `json:"gas"`         // what GAS returns, memory beyond it fails
+	FrameMemory uint64 `json:"frameMemory"` // bytes of memory a frame may use
+	PStack      int    `json:"pStack"`      // initial capacity of the parallel stack
+
+	// budget of a transaction, 0 is no limit
+	Time   duration `json:"time"`   // wall time
+	Steps  uint64   `json:"steps"`  // instructions run by the interpreters
+	Paths  uint64   `json:"paths"`  // tree nodes, graph vertices and loop cycles explored
+	Memory uint64   `json:"memory"` // bytes of EVM memory allocated, by all frames and branches
+}
+
+var LIMITS = limits{
+	Depth:       4,
+	LoopCycles:  1000,
+	Gas:         100_000_000_000,
+	FrameMemory: 100_000,
+	PStack:      100,
+	Time:        duration(30 * time.Second),
+	Steps:       100_000_000,
+	Paths:       1_000_000,
+	Memory:      1 << 30,
+}
+
+// flags setting LIMITS, they override -config
+var limit_flags = map[string]bool{
+	"maxdepth": true, "maxloop": true, "gas": true, "framememory": true, "pstack": true,
+	"txtimeout": true, "maxsteps": true, "maxpaths": true, "maxmemory": true,
+}
+
+func define_limit_flags() {
+	flag.IntVar(&LIMITS.Depth, "maxdepth", LIMITS.Depth, "nested exec frames explored below the transaction")
+	flag.IntVar(&LIMITS.LoopCycles, "maxloop", LIMITS.LoopCycles, "cycles a loop may run before the analysis fails")
+	flag.Uint64Var(&LIMITS.Gas, "gas", LIMITS.Gas, "value of GAS, memory larger than it fails the analysis")
+	flag.Uint64Var(&LIMITS.FrameMemory, "framememory", LIMITS.FrameMemory, "bytes of memory a frame may use")
+	flag.IntVar(&LIMITS.PStack, "pstack", LIMITS.PStack, "initial capacity of the parallel stack")
+	flag.DurationVar((*time.Duration)(&LIMITS.Time), "txtimeout", time.Duration(LIMITS.Time), "wall time the analysis of a transaction may take, 0 for no limit")
+	flag.Uint64Var(&LIMITS.Steps, "maxsteps", LIMITS.Steps, "instructions the analysis of a transaction may run, 0 for no limit")
+	flag.Uint64Var(&LIMITS.Paths, "maxpaths", LIMITS.Paths, "code paths (tree nodes, graph vertices, loop cycles) a transaction may explore, 0 for no limit")
+	flag.Uint64Var(&LIMITS.Memory, "maxmemory", LIMITS.Memory, "bytes of EVM memory the analysis of a transaction may allocate, 0 for no limit")
+}
+
+// reads LIMITS from a JSON file (fields missing in it keep their value),
+// limit flags given in fs win over it
+func load_limits(fs *flag.FlagSet, path string) error {
+	if path != "" {
+		given := make(map[string]string)
+		fs.Visit(func(f *flag.Flag) {
+			if limit_flags[f.Name] {
+				given[f.Name] = f.Value.String()
+			}
+		})
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&LIMITS); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		for name, value := range given {
+			if err := fs.Set(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return LIMITS.check()
+}
+
+func (l limits) check() error {
+	if l.Depth < 0 || l.LoopCycles < 0 || l.PStack < 0 || l.Time < 0 {
+		return fmt.Errorf("limits can not be negative: %s", l)
+	}
+	return nil
+}
+
+func (l limits) String() string {
+	return fmt.Sprintf("depth=%d loopCycles=%d gas=%d frameMemory=%d pStack=%d time=%s steps=%d paths=%d memory=%d",
+		l.Depth, l.LoopCycles, l.Gas, l.FrameMemory, l.PStack, time.Duration(l.Time), l.Steps, l.Paths, l.Memory)
+}
+
+// a time.Duration written as "30s" in JSON
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
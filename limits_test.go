@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadLimits(t *testing.T) {
+	defer func(l limits) { LIMITS = l }(LIMITS)
+
+	path := filepath.Join(t.TempDir(), "limits.json")
+	os.WriteFile(path, []byte(`{"depth": 6, "time": "1m"}`), 0644)
+	if err := load_limits(flag.CommandLine, path); err != nil {
+		t.Fatal(err)
+	}
+	if LIMITS.Depth != 6 || LIMITS.Time != duration(time.Minute) {
+		t.Errorf("limits not read from the file: %s", LIMITS)
+	}
+	if LIMITS.LoopCycles != 1000 {
+		t.Errorf("a limit missing in the file changed: %s", LIMITS)
+	}
+
+	for _, bad := range []string{`{"depht": 6}`, `{"time": "forever"}`, `{"depth": -1}`} {
+		os.WriteFile(path, []byte(bad), 0644)
+		if err := load_limits(flag.CommandLine, path); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+}
+
+func TestLimitsJSON(t *testing.T) {
+	data, err := json.Marshal(LIMITS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l limits
+	if err := json.Unmarshal(data, &l); err != nil {
+		t.Fatal(err)
+	}
+	if l != LIMITS {
+		t.Errorf("limits changed by a JSON round trip: %s", data)
+	}
+}
@@ -275,7 +275,7 @@ func lp_BALANCE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 
 	slot.Set(balance)
 
-	in.evm.rw_set.add(address, READ)
+	in.evm.access(*pc, BALANCE, address, READ)
 
 	return 0
 }
@@ -365,7 +365,7 @@ func lp_EXTCODESIZE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	slot.SetUint64(uint64(in.evm.state.GetCodeSize(address)))
 
 	// report access points
-	in.evm.rw_set.add(address, READ)
+	in.evm.access(*pc, EXTCODESIZE, address, READ)
 
 	return 0
 }
@@ -384,23 +384,20 @@ func lp_EXTCODECOPY(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	ctx.memory.Set(mem_offset.Uint64(), size64, codeCopy)
 
 	// report access points
-	in.evm.rw_set.add(address, READ)
+	in.evm.access(*pc, EXTCODECOPY, address, READ)
 
 	return 0
 }
 
 func lp_RETURNDATASIZE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
-	// TODO
-	ctx.stack.Push(new(uint256.Int).SetUint64(uint64(32)))
+	ctx.stack.Push(new(uint256.Int).SetUint64(uint64(len(in.evm.last_return_data()))))
 	return 0
 }
 
 func lp_RETURNDATACOPY(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
-	for i := 0; i < 3; i++ {
-		ctx.stack.Pop()
-	}
-	// TODO
-
+	mem_offset, data_offset, length := ctx.stack.Pop(), ctx.stack.Pop(), ctx.stack.Pop()
+	data := getDataBig(in.evm.last_return_data(), &data_offset, length.Uint64())
+	ctx.memory.Set(mem_offset.Uint64(), length.Uint64(), data)
 	return 0
 }
 
@@ -415,7 +412,7 @@ func lp_EXTCODEHASH(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	}
 
 	// report access points
-	in.evm.rw_set.add(address, READ)
+	in.evm.access(*pc, EXTCODEHASH, address, READ)
 
 	return 0
 }
@@ -436,7 +433,7 @@ func lp_BLOCKHASH(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 		lower = upper - 256
 	}
 	if num64 >= lower && num64 < upper {
-		num.SetBytes(in.evm.block.Hash().Bytes())
+		num.SetBytes(in.evm.get_hash(num64).Bytes())
 	} else {
 		num.Clear()
 	}
@@ -492,6 +489,11 @@ func lp_SELFBALANCE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	return 0
 }
 
+func lp_BASEFEE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
+	ctx.stack.Push(in.evm.base_fee())
+	return 0
+}
+
 /* ----- 50s: Stack, Memory, Storage and Flow Operations ----- */
 
 func lp_POP(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
@@ -514,7 +516,7 @@ func lp_MSTORE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 
 func lp_MSTORE8(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	off, val := ctx.stack.Pop(), ctx.stack.Pop()
-	ctx.memory.store[off.Uint64()] = byte(val.Uint64())
+	ctx.memory.SetByte(off.Uint64(), byte(val.Uint64()))
 	return 0
 }
 
@@ -579,7 +581,7 @@ func lp_MSIZE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 
 func lp_GAS(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	// fmt.Println("GAS")
-	ctx.stack.Push(new(uint256.Int).SetUint64(GAS_CONST))
+	ctx.stack.Push(new(uint256.Int).SetUint64(in.evm.limits.Gas))
 	return 0
 }
 
@@ -642,8 +644,7 @@ func lp_CALL(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	if bytes_size > 1 {
 		// we have many possible returns from previous execution
 		// so we dont know what is exact response
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_MANY)
 		return 0
 	} else if bytes_size == 1 {
 		// we have exactly one return from previous execution
@@ -653,8 +654,7 @@ func lp_CALL(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 		return 0
 	} else {
 		// we have no returns from previous execution
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_NONE)
 		return 0
 	}
 }
@@ -678,8 +678,7 @@ func lp_CALLCODE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	if bytes_size > 1 {
 		// we have many possible returns from previous execution
 		// so we dont know what is exact response
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_MANY)
 		return 0
 	} else if bytes_size == 1 {
 		// we have exactly one return from previous execution
@@ -689,8 +688,7 @@ func lp_CALLCODE(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 		return 0
 	} else {
 		// we have no returns from previous execution
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_NONE)
 		return 0
 	}
 }
@@ -714,8 +712,7 @@ func lp_DELEGATECALL(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	if bytes_size > 1 {
 		// we have many possible returns from previous execution
 		// so we dont know what is exact response
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_MANY)
 		return 0
 	} else if bytes_size == 1 {
 		// we have exactly one return from previous execution
@@ -725,8 +722,7 @@ func lp_DELEGATECALL(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 		return 0
 	} else {
 		// we have no returns from previous execution
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_NONE)
 		return 0
 	}
 }
@@ -749,8 +745,7 @@ func lp_STATICCALL(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 	if bytes_size > 1 {
 		// we have many possible returns from previous execution
 		// so we dont know what is exact response
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_MANY)
 		return 0
 	} else if bytes_size == 1 {
 		// we have exactly one return from previous execution
@@ -760,8 +755,7 @@ func lp_STATICCALL(pc *uint64, in *interpreter, ctx *callCtx) uint64 {
 		return 0
 	} else {
 		// we have no returns from previous execution
-		in.evm.abort = true
-		in.evm.result = false
+		in.evm.fail(FAIL_RETURN_NONE)
 		return 0
 	}
 }
@@ -4,7 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"os/exec"
+	"strconv"
 )
 
 var (
@@ -13,14 +15,59 @@ var (
 	BLOCK_INDEX    = flag.Int("block", -1, "block number to run analisys on")
 	GRAPHVIZ       = flag.Bool("graphviz", false, "generate graphviz files?")
 	LOOP           = flag.Bool("loop", false, "to loop over all blocks starting from block index")
+	SNAPSHOT       = flag.String("snapshot", "", "write everything the analysis of the block reads to this file")
+	REPLAY         = flag.String("replay", "", "analyse the block stored in a snapshot file, no chaindata needed")
+	BLOCK_JSON     = flag.String("blockjson", "", "analyse a block JSON file (eth_getBlockByNumber with transactions) instead of chaindata")
+	PRESTATE       = flag.String("prestate", "", "prestate JSON file (prestateTracer format) used with -blockjson")
+	RPC_URL        = flag.String("rpc", "", "fetch the block and its state from a JSON-RPC node instead of chaindata")
+	RPC_CACHE      = flag.String("rpccache", "", "directory to cache JSON-RPC responses in")
+	RAW_TX         = flag.String("rawtx", "", "analyse a signed raw transaction (hex RLP) as the only transaction of -block")
+	CODE           = flag.String("code", "", "analyse bytecode: hex, or @file with hex, assembly (.asm) or raw bytes")
+	CODE_INPUT     = flag.String("input", "", "calldata (hex) used with -code")
+	CODE_CALLER    = flag.String("caller", "0x0000000000000000000000000000000000000000", "caller address used with -code")
+	CODE_ADDRESS   = flag.String("address", "0x000000000000000000000000000000000000c0de", "address the code runs at, used with -code")
+	CODE_VALUE     = flag.String("value", "0", "call value (decimal or 0x hex) used with -code")
+	WHY            = flag.String("why", "", "show where the accesses of this address come from (opcode, pc, frame, call path)")
+	EXPLORER       = flag.String("explorer", EXPLORER_TREE, "tree, or graph to also build the control flow graph of every frame (-cfg and -graphviz need it)")
+	CFG_FORMAT     = flag.String("cfg", "", "write the explored CFG of every transaction as json, graphml and/or mermaid (comma separated)")
+	COVERAGE       = flag.Bool("coverage", false, "print the code the analysis explored and missed, per transaction frame")
+	STORE          = flag.String("store", "", "results database: analysed blocks are saved to it and skipped when analysed again")
+	FROM           = flag.Int("from", -1, "first block of a range to analyse (or to query)")
+	TO             = flag.Int("to", -1, "last block of the range, the last block of chaindata if not given")
+	VERDICT        = flag.String("verdict", "", "query: only blocks that are independent, dependent or failed")
+	TOUCHES        = flag.String("touches", "", "query: only blocks with a transaction accessing this address")
+	LIST           = flag.Bool("list", false, "query: print the matching blocks instead of a summary")
+	TX_WORKERS     = flag.Int("txworkers", 1, "number of transactions of a block analysed concurrently")
+	CODE_CACHE     = flag.Int("codecache", 4096, "number of code analyses (jumpdests, basic blocks) cached by code hash, 0 disables the cache")
+	SUMMARIES      = flag.Bool("summaries", true, "reuse the analysis of calls repeated in a block with the same code, input and value")
+	WORKERS        = flag.Int("workers", 1, "number of blocks of a range analysed concurrently")
+	CONFIG         = flag.String("config", "", "JSON file with the analysis limits (see -maxdepth and the other limit flags, which override it)")
+	CPU_PROFILE    = flag.String("cpuprofile", "", "write a CPU profile of the run to this file")
+	MEM_PROFILE    = flag.String("memprofile", "", "write a heap profile to this file when the run ends")
+	FORMAT         = flag.String("format", FORMAT_TEXT, "output format: text, json or ndjson (one line per block)")
+
+	TXS     tx_list // -tx, may be given more than once
+	EXPLAIN bool    // explain command
+
+	CFG_FORMATS []string // -cfg
 
-	TREE  bool = true
-	GRAPH bool = false
-	// generate dot files, 1 true any other number false
-	// works only when parse_block() called
-	DOT_FLAG int = 1
 )
 
+type tx_list []int
+
+func (l *tx_list) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *tx_list) Set(s string) error {
+	idx, err := strconv.Atoi(s)
+	if err != nil || idx < 0 {
+		return fmt.Errorf("invalid transaction index %q", s)
+	}
+	*l = append(*l, idx)
+	return nil
+}
+
 func generagte_svg() {
 	items, _ := ioutil.ReadDir(".")
 	for _, item := range items {
@@ -38,28 +85,13 @@ func generagte_svg() {
 	}
 }
 
-func main() {
-
-	flag.Parse()
-
-	if *GRAPHVIZ {
-		GRAPH = true
-	}
-
-	if *BLOCK_INDEX < 0 {
-		panic("Block index can not be negative number!")
-	}
-
-	if *LOOP {
-		GRAPH = false
-		analize_blocks(*BLOCK_INDEX)
-	} else {
-		analize_block(*BLOCK_INDEX)
-	}
+func init() {
+	define_limit_flags()
+	flag.Var(&TXS, "tx", "transaction index used with -why (all transactions if not given) and explain, may be repeated")
+}
 
-	if GRAPH {
-		generagte_svg()
-	}
+func main() {
+	os.Exit(run_cli(os.Args[1:]))
 }
 
 // failed_block := 13528
@@ -23,8 +23,10 @@ import (
 )
 
 // Memory implements a simple memory model for the ethereum virtual machine.
+// Copies made by _copy share the store until one of them writes to it.
 type Memory struct {
 	store []byte
+	refs  *int // memories sharing store, nil if never copied
 }
 
 // NewMemory returns a new memory model.
@@ -42,6 +44,7 @@ func (m *Memory) Set(offset, size uint64, value []byte) {
 		if offset+size > uint64(len(m.store)) {
 			panic("invalid memory: store empty")
 		}
+		m.own(0)
 		copy(m.store[offset:offset+size], value)
 	}
 }
@@ -55,6 +58,7 @@ func (m *Memory) Set32(offset uint64, val *uint256.Int) {
 	if offset+32 > uint64(len(m.store)) {
 		panic("invalid memory: store empty")
 	}
+	m.own(0)
 	// Zero the memory area
 	copy(m.store[offset:offset+32], []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
 	// Fill in relevant bits
@@ -64,10 +68,18 @@ func (m *Memory) Set32(offset uint64, val *uint256.Int) {
 // Resize resizes the memory to size
 func (m *Memory) Resize(size uint64) {
 	if uint64(m.Len()) < size {
+		// appending to a shared store could write past the end of the others
+		m.own(size)
 		m.store = append(m.store, make([]byte, size-uint64(m.Len()))...)
 	}
 }
 
+// SetByte sets the byte at offset, the store is resized PRIOR to it
+func (m *Memory) SetByte(offset uint64, b byte) {
+	m.own(0)
+	m.store[offset] = b
+}
+
 // GetCopy returns offset + size as a new slice
 func (m *Memory) GetCopy(offset, size uint64) (cpy []byte) {
 	if size == 0 {
@@ -84,7 +96,7 @@ func (m *Memory) GetCopy(offset, size uint64) (cpy []byte) {
 	return
 }
 
-// GetPtr returns the offset + size
+// GetPtr returns the offset + size, the slice must not be written to
 func (m *Memory) GetPtr(offset, size uint64) []byte {
 	if size == 0 {
 		return nil
@@ -122,6 +134,27 @@ func (m *Memory) Print() {
 	fmt.Println("####################")
 }
 
+// _copy forks the memory, the store is copied by the first write of either side
 func (m *Memory) _copy() *Memory {
-	return &Memory{store: m.store}
+	if m.refs == nil {
+		m.refs = new(int)
+		*m.refs = 1
+	}
+	*m.refs++
+	return &Memory{store: m.store, refs: m.refs}
+}
+
+// own copies the store, with room for size bytes, if another memory shares it
+func (m *Memory) own(size uint64) {
+	if m.refs == nil || *m.refs == 1 {
+		return
+	}
+	*m.refs--
+	m.refs = nil
+	if size < uint64(len(m.store)) {
+		size = uint64(len(m.store))
+	}
+	store := make([]byte, len(m.store), size)
+	copy(store, m.store)
+	m.store = store
 }
@@ -8,16 +8,37 @@ import (
 type mock_state struct {
 	state   map[common.Address]map[common.Hash]uint256.Int
 	balance map[common.Address]uint256.Int
+	version map[mock_key]uint64 // of the last write, 0 if never written
+
+	recording []*frame_summary // summaries of the frames running
 }
 
 func new_mock_state() mock_state {
 	state := make(map[common.Address]map[common.Hash]uint256.Int)
 	balance := make(map[common.Address]uint256.Int)
-	m := mock_state{state, balance}
+	m := mock_state{state: state, balance: balance, version: make(map[mock_key]uint64)}
 	return m
 }
 
+// the frames recording depend on the version of k they read
+func (m *mock_state) read(k mock_key) {
+	for _, s := range m.recording {
+		if _, ok := s.reads[k]; !ok && !s.written[k] {
+			s.reads[k] = m.version[k]
+		}
+	}
+}
+
+func (m *mock_state) wrote(k mock_key, value uint256.Int) {
+	m.version[k] = next_mock_version()
+	for _, s := range m.recording {
+		s.written[k] = true
+		s.writes = append(s.writes, mock_write{k, value})
+	}
+}
+
 func (m *mock_state) get_state(addr common.Address, key *common.Hash, val *uint256.Int) bool {
+	m.read(mock_key{address: addr, slot: *key})
 	if kvStorage, ok := m.state[addr]; ok {
 		if value, ok := kvStorage[*key]; ok {
 			*val = value
@@ -30,6 +51,7 @@ func (m *mock_state) get_state(addr common.Address, key *common.Hash, val *uint2
 }
 
 func (m *mock_state) set_state(addr common.Address, key *common.Hash, val uint256.Int) {
+	m.wrote(mock_key{address: addr, slot: *key}, val)
 	if kvStorage, ok := m.state[addr]; ok {
 		kvStorage[*key] = val
 	} else {
@@ -40,6 +62,7 @@ func (m *mock_state) set_state(addr common.Address, key *common.Hash, val uint25
 }
 
 func (m *mock_state) get_balance(addr common.Address) *uint256.Int {
+	m.read(mock_key{address: addr, balance: true})
 	if balance, ok := m.balance[addr]; ok {
 		return &balance
 	}
@@ -47,6 +70,7 @@ func (m *mock_state) get_balance(addr common.Address) *uint256.Int {
 }
 
 func (m *mock_state) add_balance(addr common.Address, amount *uint256.Int) {
+	m.wrote(mock_key{address: addr, balance: true}, *amount)
 	if balance, ok := m.balance[addr]; ok {
 		new_balance := balance.Add(&balance, amount)
 		m.balance[addr] = *new_balance
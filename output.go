@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/racytech/abs_evm/asm"
+)
+
+const (
+	FORMAT_TEXT   = "text"
+	FORMAT_JSON   = "json"
+	FORMAT_NDJSON = "ndjson"
+
+	// bumped when a field changes meaning or is removed, adding fields
+	// does not change it
+	OUTPUT_VERSION = 1
+)
+
+// one block of -format=json/ndjson output. Addresses are lowercase hex,
+// every list is sorted and is [] rather than null when empty.
+type block_output struct {
+	Version      int         `json:"version"`
+	Block        uint64      `json:"block"`
+	Hash         common.Hash `json:"hash"`
+	Independent  bool        `json:"independent"`
+	Limits       limits      `json:"limits"`
+	Transactions []tx_output `json:"transactions"`
+}
+
+type tx_output struct {
+	Index        int               `json:"index"`
+	Hash         *common.Hash      `json:"hash,omitempty"` // not set for -code
+	Status       string            `json:"status"`         // "ok", "failed" or "budget exceeded"
+	FailReason   string            `json:"failReason,omitempty"`
+	Selfdestruct bool              `json:"selfdestruct"`
+	Reads        []common.Address  `json:"reads"`
+	Writes       []common.Address  `json:"writes"`
+	Conflicts    []conflict_output `json:"conflicts"`
+	Limits       *limits           `json:"limits,omitempty"` // set for -code and -rawtx
+}
+
+// transaction Tx writes to an address this transaction reads ("read") or
+// writes as well ("write")
+type conflict_output struct {
+	Tx   int    `json:"tx"`
+	Mode string `json:"mode"`
+}
+
+// one access with where it comes from, see report.go
+type access_output struct {
+	Tx       int            `json:"tx"`
+	Address  common.Address `json:"address"`
+	Mode     string         `json:"mode"`   // "read" or "write"
+	Opcode   string         `json:"opcode"` // instruction that made the access
+	PC       uint64         `json:"pc"`
+	Depth    int            `json:"depth"` // call depth, 0 is the transaction
+	Frame    common.Address `json:"frame"` // contract executing the instruction
+	CodeHash common.Hash    `json:"codeHash"`
+	CallPath string         `json:"callPath"` // "0xa -CALL@pc-> 0xb ...", pcs are in the calling frame
+}
+
+// output of -why
+type provenance_output struct {
+	Version  int             `json:"version"`
+	Block    uint64          `json:"block"`
+	Address  common.Address  `json:"address"`
+	Accesses []access_output `json:"accesses"`
+}
+
+// output of explain
+type explain_output struct {
+	Version   int                    `json:"version"`
+	Block     uint64                 `json:"block"`
+	First     int                    `json:"first"`  // index of the earlier transaction
+	Second    int                    `json:"second"` // index of the later one
+	Limits    limits                 `json:"limits"`
+	Conflicts []conflict_explanation `json:"conflicts"`
+}
+
+// accesses of Address making the conflict, by each side
+type conflict_explanation struct {
+	Address common.Address  `json:"address"`
+	Kind    string          `json:"kind"` // "RAW", "WAR" or "WAW"
+	First   []access_output `json:"first"`
+	Second  []access_output `json:"second"`
+}
+
+func new_access_output(tx int, t tuple) access_output {
+	return access_output{
+		Tx:       tx,
+		Address:  t.addr,
+		Mode:     t.mode_name(),
+		Opcode:   asm.Name(t.instruction),
+		PC:       t.pc,
+		Depth:    t.depth,
+		Frame:    t.initiator,
+		CodeHash: t.code_hash,
+		CallPath: t.call_path,
+	}
+}
+
+func check_format(format string) error {
+	switch format {
+	case FORMAT_TEXT, FORMAT_JSON, FORMAT_NDJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, expected text, json or ndjson", format)
+}
+
+func new_tx_output(idx int, _evm *evm) tx_output {
+	out := tx_output{
+		Index:        idx,
+		Status:       "ok",
+		Selfdestruct: _evm.suicide,
+		Reads:        sorted_addresses(_evm.rw_set.read_set),
+		Writes:       sorted_addresses(_evm.rw_set.write_set),
+		Conflicts:    []conflict_output{},
+	}
+	if !_evm.result {
+		out.Status = "failed"
+		if _evm.over_budget() {
+			out.Status = FAIL_BUDGET
+		}
+		out.FailReason = _evm.fail_reason
+	}
+
+	seen := make(map[conflict_output]bool)
+	for _, c := range _evm.rw_set.cross_set {
+		mode := "read"
+		if c.mode == WRITE {
+			mode = "write"
+		}
+		conflict := conflict_output{Tx: c.txn_idx, Mode: mode}
+		if !seen[conflict] {
+			seen[conflict] = true
+			out.Conflicts = append(out.Conflicts, conflict)
+		}
+	}
+	sort.Slice(out.Conflicts, func(i, j int) bool {
+		a, b := out.Conflicts[i], out.Conflicts[j]
+		if a.Tx != b.Tx {
+			return a.Tx < b.Tx
+		}
+		return a.Mode < b.Mode
+	})
+	return out
+}
+
+func new_block_output(block *types.Block, result bool, results []*evm) block_output {
+	out := block_output{
+		Version:      OUTPUT_VERSION,
+		Block:        block.NumberU64(),
+		Hash:         block.Hash(),
+		Independent:  result,
+		Limits:       LIMITS,
+		Transactions: make([]tx_output, 0, len(results)),
+	}
+	txs := block.Transactions()
+	for i, _evm := range results {
+		tx := new_tx_output(i, _evm)
+		if i < len(txs) {
+			hash := txs[i].Hash()
+			tx.Hash = &hash
+		}
+		out.Transactions = append(out.Transactions, tx)
+	}
+	return out
+}
+
+// json is indented, ndjson is a single line; both end with a newline so
+// that outputs of consecutive blocks can be streamed
+func write_json(w io.Writer, format string, v interface{}) error {
+	enc := json.NewEncoder(w)
+	if format == FORMAT_JSON {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(v)
+}
+
+// writes the results of a block in the given format
+func write_results(w io.Writer, format string, block *types.Block, result bool, results []*evm) error {
+	if format != FORMAT_TEXT {
+		return write_json(w, format, new_block_output(block, result, results))
+	}
+
+	fmt.Fprintf(w, "\nIndependent execution for block #%d: %t\n", block.NumberU64(), result)
+	fmt.Fprintln(w, "Number of transactions: ", len(results))
+	fmt.Fprintln(w, "Limits:", LIMITS)
+	for i, _evm := range results {
+		_evm.rw_set.write(w, i)
+		if !_evm.result {
+			fmt.Fprintln(w, "analysis failed:", _evm.fail_reason)
+		}
+	}
+	fmt.Fprintln(w)
+	return nil
+}
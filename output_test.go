@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/tests"
+)
+
+func TestJSONOutput(t *testing.T) {
+	f := load_fixture(t, filepath.Join("testdata", "fixtures", "complicated.json"))
+	blocks := f.make_blocks(t)
+	chainCfg, _, _ := tests.GetChainConfig("London")
+
+	// analyses the block from scratch, so map iteration differs between runs
+	render := func(format string) []byte {
+		results := analize(context.Background(), blocks[0], new_alloc_state(f.alloc()), chainCfg, fixture_hashes(blocks))
+		result := handle_results(results, 1)
+		var buf bytes.Buffer
+		if err := write_results(&buf, format, blocks[0], result, results); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	first := render(FORMAT_JSON)
+	for i := 0; i < 5; i++ {
+		if again := render(FORMAT_JSON); !bytes.Equal(first, again) {
+			t.Fatalf("output is not stable:\n%s\n%s", first, again)
+		}
+	}
+
+	var out block_output
+	if err := json.Unmarshal(first, &out); err != nil {
+		t.Fatal(err)
+	}
+	expect := f.Blocks[0].Expect
+	if out.Version != OUTPUT_VERSION || out.Block != 1 || out.Hash != blocks[0].Hash() || out.Independent != expect.Independent {
+		t.Errorf("unexpected block fields: %+v", out)
+	}
+	if len(out.Transactions) != len(expect.Txs) {
+		t.Fatalf("%d transactions, want %d", len(out.Transactions), len(expect.Txs))
+	}
+	for i, tx := range out.Transactions {
+		if tx.Index != i || tx.Hash == nil || *tx.Hash != blocks[0].Transactions()[i].Hash() {
+			t.Errorf("tx %d: index %d, hash %v", i, tx.Index, tx.Hash)
+		}
+		if len(tx.Reads) != len(expect.Txs[i].Reads) || len(tx.Writes) != len(expect.Txs[i].Writes) {
+			t.Errorf("tx %d: %d reads, %d writes", i, len(tx.Reads), len(tx.Writes))
+		}
+		for j := 1; j < len(tx.Reads); j++ {
+			if bytes.Compare(tx.Reads[j-1][:], tx.Reads[j][:]) >= 0 {
+				t.Errorf("tx %d: reads are not sorted", i)
+			}
+		}
+	}
+
+	conflicts := 0
+	for _, tx := range out.Transactions {
+		conflicts += len(tx.Conflicts)
+	}
+	if conflicts == 0 {
+		t.Error("no conflicts reported for a dependent block")
+	}
+
+	// empty lists are not null
+	if strings.Contains(string(first), "null") {
+		t.Errorf("output contains null:\n%s", first)
+	}
+
+	line := render(FORMAT_NDJSON)
+	if bytes.Count(line, []byte("\n")) != 1 || !bytes.HasSuffix(line, []byte("\n")) {
+		t.Errorf("ndjson is not a single line:\n%s", line)
+	}
+}
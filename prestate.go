@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+	"github.com/ledgerwatch/erigon/core"
+)
+
+// state of the accounts a block touches, in the format of geth's
+// prestateTracer: {address: {balance, nonce, code, storage}}
+type prestate map[common.Address]*prestate_account
+
+type prestate_account struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Nonce   uint64                      `json:"nonce"`
+	Code    hexutil.Bytes               `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// other tools write quantities as hex or decimal strings, or as numbers,
+// and storage words without leading zeros, accept all of them
+func (a *prestate_account) UnmarshalJSON(data []byte) error {
+	var dec struct {
+		Balance json.RawMessage   `json:"balance"`
+		Nonce   json.RawMessage   `json:"nonce"`
+		Code    hexutil.Bytes     `json:"code"`
+		Storage map[string]string `json:"storage"`
+	}
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return err
+	}
+
+	balance, err := parse_quantity(dec.Balance)
+	if err != nil {
+		return fmt.Errorf("balance: %w", err)
+	}
+	nonce, err := parse_quantity(dec.Nonce)
+	if err != nil || !nonce.IsUint64() {
+		return fmt.Errorf("nonce: invalid value %s", dec.Nonce)
+	}
+
+	a.Balance = (*hexutil.Big)(balance)
+	a.Nonce = nonce.Uint64()
+	a.Code = dec.Code
+	a.Storage = nil
+	if len(dec.Storage) > 0 {
+		a.Storage = make(map[common.Hash]common.Hash, len(dec.Storage))
+		for key, value := range dec.Storage {
+			a.Storage[common.HexToHash(key)] = common.HexToHash(value)
+		}
+	}
+	return nil
+}
+
+// hex after 0x, decimal otherwise
+func parse_quantity(raw json.RawMessage) (*big.Int, error) {
+	s := strings.Trim(string(raw), `"`)
+	if s == "" || s == "null" {
+		return new(big.Int), nil
+	}
+	base := 10
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s, base = s[2:], 16
+	}
+	v, ok := new(big.Int).SetString(s, base)
+	if !ok || v.Sign() < 0 {
+		return nil, fmt.Errorf("invalid quantity %s", raw)
+	}
+	return v, nil
+}
+
+// decodes a prestate map, a JSON-RPC response holding one, or the per
+// transaction results of debug_traceBlock*; in the last case the first
+// transaction touching an account has its balance, nonce and code before
+// the block, and the first touching a slot its value
+func decode_prestate(data []byte) (prestate, error) {
+	data = bytes.TrimSpace(rpc_result(data))
+
+	if !bytes.HasPrefix(data, []byte("[")) {
+		var p prestate
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	var traces []struct {
+		Result prestate `json:"result"`
+	}
+	if err := json.Unmarshal(data, &traces); err != nil {
+		return nil, err
+	}
+	p := make(prestate)
+	for _, trace := range traces {
+		for addr, account := range trace.Result {
+			first, ok := p[addr]
+			if !ok {
+				p[addr] = account
+				continue
+			}
+			for key, value := range account.Storage {
+				if _, ok := first.Storage[key]; ok {
+					continue
+				}
+				if first.Storage == nil {
+					first.Storage = make(map[common.Hash]common.Hash)
+				}
+				first.Storage[key] = value
+			}
+		}
+	}
+	return p, nil
+}
+
+func read_prestate(path string) (prestate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decode_prestate(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+func (p prestate) alloc() core.GenesisAlloc {
+	alloc := make(core.GenesisAlloc, len(p))
+	for addr, account := range p {
+		balance := new(big.Int)
+		if account.Balance != nil {
+			balance = account.Balance.ToInt()
+		}
+		alloc[addr] = core.GenesisAccount{
+			Balance: balance,
+			Nonce:   account.Nonce,
+			Code:    account.Code,
+			Storage: account.Storage,
+		}
+	}
+	return alloc
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common"
+)
+
+func TestDecodePrestate(t *testing.T) {
+	a := common.HexToAddress("0x0a")
+	b := common.HexToAddress("0x0b")
+
+	// debug_traceBlockByNumber with the prestateTracer, b is modified by the
+	// first transaction so only its first state is the state before the block;
+	// slot 1 of a is written by the first transaction, slot 2 read by the second
+	data := []byte(`[
+		{"result": {
+			"0x000000000000000000000000000000000000000a": {"balance": "0x10", "nonce": 2, "code": "0x6001", "storage": {"0x01": "0x2a"}},
+			"0x000000000000000000000000000000000000000b": {"balance": "100", "nonce": "0x1"}
+		}},
+		{"result": {
+			"0x000000000000000000000000000000000000000a": {"balance": "0x11", "nonce": 3, "code": "0x6001", "storage": {"0x01": "0x2b", "0x02": "0x07"}},
+			"0x000000000000000000000000000000000000000b": {"balance": "0x0", "nonce": 5, "storage": {"0x03": "0x01"}}
+		}}
+	]`)
+
+	pre, err := decode_prestate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pre) != 2 {
+		t.Fatalf("%d accounts, want 2", len(pre))
+	}
+	alloc := pre.alloc()
+	if got := alloc[a]; got.Balance.Int64() != 16 || got.Nonce != 2 || len(got.Code) != 2 ||
+		got.Storage[common.HexToHash("0x01")] != common.HexToHash("0x2a") || got.Storage[common.HexToHash("0x02")] != common.HexToHash("0x07") {
+		t.Errorf("account a: %+v", got)
+	}
+	if got := alloc[b]; got.Balance.Int64() != 100 || got.Nonce != 1 || got.Storage[common.HexToHash("0x03")] != common.HexToHash("0x01") {
+		t.Errorf("account b: %+v", got)
+	}
+
+	// a plain map wrapped in a JSON-RPC response
+	pre, err = decode_prestate([]byte(`{"jsonrpc":"2.0","id":1,"result":{"0x000000000000000000000000000000000000000a":{"balance":"0x1"}}}`))
+	if err != nil || pre[a] == nil || pre[a].Balance.ToInt().Int64() != 1 {
+		t.Fatalf("rpc response: %v %v", pre, err)
+	}
+
+	if _, err := decode_prestate([]byte(`{"0x000000000000000000000000000000000000000a":{"nonce":"-1"}}`)); err == nil {
+		t.Error("negative nonce should be rejected")
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	for s, want := range map[string]int64{`"0x10"`: 16, `"0X1f"`: 31, `"010"`: 10, `"100"`: 100, `7`: 7, `""`: 0, `null`: 0} {
+		if v, err := parse_quantity([]byte(s)); err != nil || v.Int64() != want {
+			t.Errorf("%s: %v %v, want %d", s, v, err, want)
+		}
+	}
+	for _, s := range []string{`"0b101"`, `"0o17"`, `"1_000"`, `"0x1_0"`, `"0x"`, `"0x-1"`, `"-1"`, `"12a"`} {
+		if v, err := parse_quantity([]byte(s)); err == nil {
+			t.Errorf("%s accepted as %v", s, v)
+		}
+	}
+}
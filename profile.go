@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// starts -cpuprofile, the returned function stops it and writes
+// -memprofile; both are read with go tool pprof
+func start_profiles(cpu, mem string) (func(), error) {
+	var cpu_file *os.File
+	if cpu != "" {
+		var err error
+		if cpu_file, err = os.Create(cpu); err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpu_file); err != nil {
+			cpu_file.Close()
+			return nil, err
+		}
+	}
+
+	return func() {
+		if cpu_file != nil {
+			pprof.StopCPUProfile()
+			cpu_file.Close()
+		}
+		if mem == "" {
+			return
+		}
+		f, err := os.Create(mem)
+		if err != nil {
+			log.Print("Error writing memory profile: ", err)
+			return
+		}
+		defer f.Close()
+		runtime.GC() // up to date statistics
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			log.Print("Error writing memory profile: ", err)
+		}
+	}, nil
+}
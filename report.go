@@ -2,98 +2,189 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
 
 	"github.com/ledgerwatch/erigon/common"
+	"github.com/racytech/abs_evm/asm"
 )
 
+// exec frame of a transaction, the first one is the transaction itself
+type frame struct {
+	address   common.Address // contract the frame runs at
+	code_hash common.Hash
+	op        byte   // instruction that created the frame (CALL for a message call transaction)
+	pc        uint64 // pc of op in the parent frame
+}
+
+// where an access comes from
 type tuple struct {
-	depth       int
-	instraction string
-	initiator   string
-	addr        string
+	depth       int            // call depth of the frame, 0 is the transaction
+	instruction byte           // opcode that made the access
+	pc          uint64         // pc of the instruction
+	mode        int            // READ or WRITE
+	initiator   common.Address // address of the frame
+	code_hash   common.Hash    // code the frame runs
+	addr        common.Address // accessed account
+	call_path   string         // frames that led to the access
 }
 
 type report struct {
 	blockNumber  uint64
 	txnIDX       int
-	currentLevel int
 	accessPoints []tuple
-	depth        int
+	seen         map[tuple]bool
+	depth        int // deepest frame with an access
 }
 
-func newTuple(depth int, instraction string, initiator common.Hash, addr common.Hash) tuple {
-	return tuple{depth, instraction, initiator.String(), addr.String()}
+func newTuple(frames []frame, pc uint64, op byte, addr common.Address, mode int) tuple {
+	var top frame // instructions run on their own have no frame
+	depth := 0
+	if len(frames) > 0 {
+		top, depth = frames[len(frames)-1], len(frames)-1
+	}
+	return tuple{
+		depth:       depth,
+		instruction: op,
+		pc:          pc,
+		mode:        mode,
+		initiator:   top.address,
+		code_hash:   top.code_hash,
+		addr:        addr,
+		call_path:   call_path(frames),
+	}
 }
 
 func newReport(bn uint64, idx int) *report {
-	var ac []tuple
-	r := &report{blockNumber: bn, txnIDX: idx, currentLevel: 0, accessPoints: ac, depth: 0}
-
-	return r
+	return &report{blockNumber: bn, txnIDX: idx, seen: make(map[tuple]bool)}
 }
 
-func (t tuple) is_equal(rhs tuple) bool {
-	return t.depth == rhs.depth && t.instraction == rhs.instraction && t.initiator == rhs.initiator && t.addr == rhs.addr
-}
-
-// increment current level by one
-func (r *report) inc() {
-	r.currentLevel++
-	r.depth++
+// 0xa -CALL-> 0xb -DELEGATECALL@120-> 0xc, pcs are in the calling frame
+func call_path(frames []frame) string {
+	var b strings.Builder
+	for i, f := range frames {
+		if i == 0 {
+			b.WriteString(f.address.Hex())
+			continue
+		}
+		fmt.Fprintf(&b, " -%s@%d-> %s", asm.Name(f.op), f.pc, f.address.Hex())
+	}
+	return b.String()
 }
 
-// decrement current level by one
-func (r *report) dec() {
-	r.currentLevel--
+func (t tuple) mode_name() string {
+	if t.mode == WRITE {
+		return "write"
+	}
+	return "read"
 }
 
-func (r *report) getLevel() int {
-	return r.currentLevel
+// the same access on the same path is reported once
+func (r *report) add(t_in tuple) {
+	if r.seen[t_in] {
+		return
+	}
+	r.seen[t_in] = true
+	r.accessPoints = append(r.accessPoints, t_in)
+	if t_in.depth > r.depth {
+		r.depth = t_in.depth
+	}
 }
 
-func (r *report) add(t_in tuple) {
-	if len(r.accessPoints) > 0 {
-		for _, t := range r.accessPoints {
-			added := t.is_equal(t_in)
-			if added {
-				return
-			}
+// accesses of addr, mode 0 for both reads and writes, sorted by call path,
+// then pc
+func (r *report) why(addr common.Address, mode int) []tuple {
+	var result []tuple
+	for _, t := range r.accessPoints {
+		if t.addr == addr && (mode == 0 || t.mode == mode) {
+			result = append(result, t)
 		}
-		r.accessPoints = append(r.accessPoints, t_in)
-
-	} else {
-		r.accessPoints = append(r.accessPoints, t_in)
 	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.call_path != b.call_path {
+			return a.call_path < b.call_path
+		}
+		return a.pc < b.pc
+	})
+	return result
+}
 
+func (t tuple) write(w io.Writer) {
+	tabs := strings.Repeat("\t", t.depth)
+	fmt.Fprintf(w, "%v----\n", tabs)
+	fmt.Fprintf(w, "%vdepth-%d. instruction: %s at pc %d (%s)\n", tabs, t.depth, asm.Name(t.instruction), t.pc, t.mode_name())
+	fmt.Fprintf(w, "%vinitiator: %s, code hash %s\n", tabs, t.initiator.Hex(), t.code_hash.Hex())
+	fmt.Fprintf(w, "%vaccess point: %s\n", tabs, t.addr.Hex())
+	fmt.Fprintf(w, "%vcall path: %s\n", tabs, t.call_path)
+	fmt.Fprintf(w, "%v----\n", tabs)
+	fmt.Fprintln(w)
 }
 
 func (t tuple) print() {
-	tabs := ""
-	for i := 0; i < t.depth; i++ {
-		tabs += "\t"
-	}
-	fmt.Printf("%v----\n", tabs)
-	fmt.Printf("%vdepth-%d. instraction: %v\n", tabs, t.depth, t.instraction)
-	fmt.Printf("%vinitiator: %s\n", tabs, t.initiator)
-	fmt.Printf("%vaccess point: %s\n", tabs, t.addr)
-	fmt.Printf("%v----\n", tabs)
-	fmt.Println()
+	t.write(os.Stdout)
 }
 
 func (r *report) print_report() {
-	flag := false
 	if len(r.accessPoints) > 0 {
-		flag = true
-	}
-	if flag {
 		fmt.Println("--------------------------------------------")
-		fmt.Printf("block number: %d, txn index: %d, recursions: %d\n", r.blockNumber, r.txnIDX, r.depth-1)
+		fmt.Printf("block number: %d, txn index: %d, recursions: %d\n", r.blockNumber, r.txnIDX, r.depth)
 		fmt.Println("access points:")
 		for _, t := range r.accessPoints {
 			t.print()
 		}
+	}
+}
+
+// answers "why does tx access addr": every access of addr by the
+// transactions txs (all transactions if empty) with where it comes from
+func write_provenance(w io.Writer, format string, block_number uint64, results []*evm, txs []int, addr common.Address) error {
+	out := provenance_output{
+		Version:  OUTPUT_VERSION,
+		Block:    block_number,
+		Address:  addr,
+		Accesses: []access_output{},
+	}
+	for i, _evm := range results {
+		if !selected(txs, i) {
+			continue
+		}
+		for _, t := range _evm.report.why(addr, 0) {
+			out.Accesses = append(out.Accesses, new_access_output(i, t))
+		}
+	}
 
-		// time.Sleep(time.Second * 2)
+	if format != FORMAT_TEXT {
+		return write_json(w, format, out)
 	}
 
+	if len(out.Accesses) == 0 {
+		fmt.Fprintf(w, "\nno access of %s\n", addr.Hex())
+		return nil
+	}
+	for i, _evm := range results {
+		if !selected(txs, i) {
+			continue
+		}
+		tuples := _evm.report.why(addr, 0)
+		if len(tuples) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n**** transaction %d accesses %s ****\n", i, addr.Hex())
+		for _, t := range tuples {
+			t.write(w)
+		}
+	}
+	return nil
+}
+
+func selected(txs []int, idx int) bool {
+	for _, tx := range txs {
+		if tx == idx {
+			return true
+		}
+	}
+	return len(txs) == 0
 }
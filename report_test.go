@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/crypto"
+	"github.com/racytech/abs_evm/asm"
+)
+
+func TestAccessProvenance(t *testing.T) {
+	a, b := common.HexToAddress("0x0a"), common.HexToAddress("0x0b")
+	callee := asm.MustAssemble("PUSH1 1 PUSH1 0 SSTORE PUSH1 32 PUSH1 0 RETURN")
+	_evm := run_code(code_run{
+		// CALL is at pc 13
+		code:    asm.MustAssemble("PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0x0b GAS CALL POP PUSH1 0x0b BALANCE STOP"),
+		address: a,
+		pre:     prestate{b: &prestate_account{Code: callee}},
+	})
+	if !_evm.result {
+		t.Fatalf("analysis failed: %s", _evm.fail_reason)
+	}
+
+	writes := _evm.report.why(b, WRITE)
+	if len(writes) != 1 {
+		t.Fatalf("%d writes of %s, want 1", len(writes), b.Hex())
+	}
+	w := writes[0]
+	want := tuple{
+		depth:       1,
+		instruction: SSTORE,
+		pc:          4,
+		mode:        WRITE,
+		initiator:   b,
+		code_hash:   crypto.Keccak256Hash(callee),
+		addr:        b,
+		call_path:   a.Hex() + " -CALL@13-> " + b.Hex(),
+	}
+	if w != want {
+		t.Errorf("got  %+v\nwant %+v", w, want)
+	}
+
+	reads := _evm.report.why(b, READ)
+	if len(reads) != 1 || reads[0].instruction != BALANCE || reads[0].depth != 0 || reads[0].call_path != a.Hex() {
+		t.Errorf("unexpected reads of %s: %+v", b.Hex(), reads)
+	}
+
+	var buf bytes.Buffer
+	if err := write_provenance(&buf, FORMAT_TEXT, 0, []*evm{_evm}, []int{0}, b); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"SSTORE at pc 4 (write)", "BALANCE at pc 17 (read)", want.call_path} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("output has no %q:\n%s", s, buf.String())
+		}
+	}
+}
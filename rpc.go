@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+	"github.com/ledgerwatch/erigon/crypto"
+	"github.com/ledgerwatch/erigon/params"
+	"github.com/ledgerwatch/erigon/tests"
+)
+
+type rpc_request struct {
+	Jsonrpc string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpc_response struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// JSON-RPC client. Everything it asks for is pinned to a block, so answers
+// never change and are kept in cache_dir (if set) across runs.
+type rpc_client struct {
+	url       string
+	cache_dir string
+	http      *http.Client
+
+	mu     sync.Mutex
+	memory map[string]json.RawMessage
+}
+
+func new_rpc_client(url, cache_dir string) *rpc_client {
+	return &rpc_client{
+		url:       url,
+		cache_dir: cache_dir,
+		http:      &http.Client{Timeout: time.Minute},
+		memory:    make(map[string]json.RawMessage),
+	}
+}
+
+func cache_key(method string, params []interface{}) string {
+	data, _ := json.Marshal(params)
+	return crypto.Keccak256Hash([]byte(method), data).Hex()[2:]
+}
+
+func (c *rpc_client) cached(key string) (json.RawMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if result, ok := c.memory[key]; ok {
+		return result, true
+	}
+	if c.cache_dir == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(filepath.Join(c.cache_dir, key[:2], key))
+	if err != nil {
+		return nil, false
+	}
+	c.memory[key] = data
+	return data, true
+}
+
+func (c *rpc_client) store(key string, result json.RawMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.memory[key] = result
+	if c.cache_dir == "" {
+		return nil
+	}
+	dir := filepath.Join(c.cache_dir, key[:2])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, key), result, 0644)
+}
+
+// sends the requests which are not cached as a single batch, results are
+// returned in the order of the requests
+func (c *rpc_client) batch(reqs []rpc_request) ([]json.RawMessage, error) {
+	results := make([]json.RawMessage, len(reqs))
+	keys := make([]string, len(reqs))
+
+	var pending []rpc_request
+	for i := range reqs {
+		keys[i] = cache_key(reqs[i].Method, reqs[i].Params)
+		if result, ok := c.cached(keys[i]); ok {
+			results[i] = result
+			continue
+		}
+		req := reqs[i]
+		req.Jsonrpc, req.ID = "2.0", i
+		pending = append(pending, req)
+	}
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	body, err := json.Marshal(pending)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rpc: %s", resp.Status)
+	}
+
+	var responses []rpc_response
+	if err := json.NewDecoder(resp.Body).Decode(&responses); err != nil {
+		return nil, fmt.Errorf("rpc: %w", err)
+	}
+	for _, r := range responses {
+		if r.ID < 0 || r.ID >= len(reqs) || results[r.ID] != nil {
+			return nil, fmt.Errorf("rpc: unexpected response id %d", r.ID)
+		}
+		if r.Error != nil {
+			return nil, fmt.Errorf("rpc: %s: %s (%d)", reqs[r.ID].Method, r.Error.Message, r.Error.Code)
+		}
+		results[r.ID] = r.Result
+		if string(r.Result) == "null" { // not known (yet), do not cache
+			continue
+		}
+		if err := c.store(keys[r.ID], r.Result); err != nil {
+			return nil, err
+		}
+	}
+	for i, result := range results {
+		if result == nil {
+			return nil, fmt.Errorf("rpc: no response to %s", reqs[i].Method)
+		}
+	}
+	return results, nil
+}
+
+func (c *rpc_client) call(result interface{}, method string, params ...interface{}) error {
+	results, err := c.batch([]rpc_request{{Method: method, Params: params}})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(results[0], result)
+}
+
+func (c *rpc_client) block_by_number(n uint64) (*types.Block, error) {
+	var raw json.RawMessage
+	if err := c.call(&raw, "eth_getBlockByNumber", hexutil.Uint64(n), true); err != nil {
+		return nil, err
+	}
+	if string(raw) == "null" {
+		return nil, fmt.Errorf("block %d not found", n)
+	}
+	return decode_block_json(raw)
+}
+
+// canonical block hashes for BLOCKHASH
+func (c *rpc_client) get_hash() func(uint64) common.Hash {
+	return func(n uint64) common.Hash {
+		var header struct {
+			Hash common.Hash `json:"hash"`
+		}
+		if err := c.call(&header, "eth_getBlockByNumber", hexutil.Uint64(n), false); err != nil {
+			log.Fatalf("Error reading block hash %d: %s\n", n, err)
+		}
+		return header.Hash
+	}
+}
+
+// state reader that loads accounts and storage lazily over JSON-RPC,
+// as of the end of block 'number'. Safe for concurrent use.
+type rpc_reader struct {
+	client *rpc_client
+	number hexutil.Uint64
+	queue  storage_queue
+}
+
+// how long a storage read waits for others before a batch is sent
+const storage_window = time.Millisecond
+
+type storage_slot struct {
+	address common.Address
+	key     common.Hash
+}
+
+// storage reads wait here while a batch is in flight and go out together
+// in the next one, transactions analysed concurrently share requests
+type storage_queue struct {
+	mu      sync.Mutex
+	busy    bool // a reader is sending the queue
+	waiting []*storage_read
+}
+
+type storage_read struct {
+	slot  storage_slot
+	value []byte
+	err   error
+	wake  chan bool // true when answered, false when it is its turn to send
+}
+
+func new_rpc_reader(client *rpc_client, number uint64) *rpc_reader {
+	return &rpc_reader{client: client, number: hexutil.Uint64(number)}
+}
+
+func (r *rpc_reader) code(address common.Address) ([]byte, error) {
+	var code hexutil.Bytes
+	err := r.client.call(&code, "eth_getCode", address, r.number)
+	return code, err
+}
+
+// balance, nonce and code of an account
+func (r *rpc_reader) account_requests(address common.Address) []rpc_request {
+	return []rpc_request{
+		{Method: "eth_getBalance", Params: []interface{}{address, r.number}},
+		{Method: "eth_getTransactionCount", Params: []interface{}{address, r.number}},
+		{Method: "eth_getCode", Params: []interface{}{address, r.number}},
+	}
+}
+
+func (r *rpc_reader) ReadAccountData(address common.Address) (*accounts.Account, error) {
+	results, err := r.client.batch(r.account_requests(address))
+	if err != nil {
+		return nil, err
+	}
+
+	var balance hexutil.Big
+	var nonce hexutil.Uint64
+	var code hexutil.Bytes
+	for i, v := range []interface{}{&balance, &nonce, &code} {
+		if err := json.Unmarshal(results[i], v); err != nil {
+			return nil, err
+		}
+	}
+
+	// empty accounts do not exist since EIP-161
+	if balance.ToInt().Sign() == 0 && nonce == 0 && len(code) == 0 {
+		return nil, nil
+	}
+
+	acc := accounts.NewAccount()
+	acc.Initialised = true
+	acc.Nonce = uint64(nonce)
+	acc.Balance.SetFromBig(balance.ToInt())
+	if len(code) > 0 {
+		acc.CodeHash = crypto.Keccak256Hash(code)
+		acc.Incarnation = 1
+	}
+	return &acc, nil
+}
+
+func (r *rpc_reader) ReadAccountStorage(address common.Address, incarnation uint64, key *common.Hash) ([]byte, error) {
+	slot := storage_slot{address, *key}
+	req := r.storage_request(slot)
+	if result, ok := r.client.cached(cache_key(req.Method, req.Params)); ok {
+		return storage_value(result)
+	}
+
+	read := &storage_read{slot: slot, wake: make(chan bool, 1)}
+	q := &r.queue
+	q.mu.Lock()
+	q.waiting = append(q.waiting, read)
+	wait := q.busy
+	q.busy = true
+	q.mu.Unlock()
+	if wait {
+		if <-read.wake {
+			return read.value, read.err
+		}
+	} else {
+		// nothing in flight, give reads made at the same time a chance
+		// to join
+		time.Sleep(storage_window)
+	}
+
+	// sends the queue, this read included, then hands it over to the
+	// first read queued meanwhile
+	q.mu.Lock()
+	reads := q.waiting
+	q.waiting = nil
+	q.mu.Unlock()
+	r.send(reads)
+
+	q.mu.Lock()
+	if len(q.waiting) > 0 {
+		q.waiting[0].wake <- false
+	} else {
+		q.busy = false
+	}
+	q.mu.Unlock()
+	return read.value, read.err
+}
+
+// answers the reads with one batch
+func (r *rpc_reader) send(reads []*storage_read) {
+	slots := make([]storage_slot, len(reads))
+	for i, read := range reads {
+		slots[i] = read.slot
+	}
+	values, err := r.read_storage(slots)
+	for i, read := range reads {
+		if err == nil {
+			read.value = values[i]
+		}
+		read.err = err
+		read.wake <- true
+	}
+}
+
+func (r *rpc_reader) storage_request(s storage_slot) rpc_request {
+	return rpc_request{Method: "eth_getStorageAt", Params: []interface{}{s.address, s.key, r.number}}
+}
+
+func storage_value(result json.RawMessage) ([]byte, error) {
+	var value hexutil.Bytes
+	if err := json.Unmarshal(result, &value); err != nil {
+		return nil, err
+	}
+	return common.TrimLeftZeroes(value), nil
+}
+
+// reads the slots in a single batch
+func (r *rpc_reader) read_storage(slots []storage_slot) ([][]byte, error) {
+	reqs := make([]rpc_request, len(slots))
+	for i, s := range slots {
+		reqs[i] = r.storage_request(s)
+	}
+	results, err := r.client.batch(reqs)
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(slots))
+	for i, result := range results {
+		if values[i], err = storage_value(result); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// fetches in one batch what every transaction of the block reads: the
+// coinbase, senders and recipients, and the accounts and slots of the
+// access lists; the client keeps the answers for the analysis
+func (r *rpc_reader) prefetch(block *types.Block, chainCfg *params.ChainConfig) error {
+	var reqs []rpc_request
+	seen_accounts := make(map[common.Address]bool)
+	account := func(address common.Address) {
+		if !seen_accounts[address] {
+			seen_accounts[address] = true
+			reqs = append(reqs, r.account_requests(address)...)
+		}
+	}
+	seen_slots := make(map[storage_slot]bool)
+
+	account(block.Coinbase())
+	for _, txn := range block.Transactions() {
+		cfg := *chainCfg
+		cfg.ChainID = txn.GetChainID().ToBig()
+		if sender, err := txn.Sender(*types.MakeSigner(&cfg, block.NumberU64())); err == nil {
+			account(sender)
+		}
+		if to := txn.GetTo(); to != nil {
+			account(*to)
+		}
+		for _, tuple := range txn.GetAccessList() {
+			account(tuple.Address)
+			for _, key := range tuple.StorageKeys {
+				if s := (storage_slot{tuple.Address, key}); !seen_slots[s] {
+					seen_slots[s] = true
+					reqs = append(reqs, r.storage_request(s))
+				}
+			}
+		}
+	}
+	_, err := r.client.batch(reqs)
+	return err
+}
+
+// storage reads may be sent together, see cached_reader
+func (r *rpc_reader) concurrent() {}
+
+func (r *rpc_reader) ReadAccountCode(address common.Address, incarnation uint64, codeHash common.Hash) ([]byte, error) {
+	return r.code(address)
+}
+
+func (r *rpc_reader) ReadAccountCodeSize(address common.Address, incarnation uint64, codeHash common.Hash) (int, error) {
+	code, err := r.code(address)
+	return len(code), err
+}
+
+func (r *rpc_reader) ReadAccountIncarnation(address common.Address) (uint64, error) {
+	code, err := r.code(address)
+	if err != nil || len(code) == 0 {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// fetches block_number from a node and analyses it against the state of
+// its parent block
+func rpc_analize(ctx context.Context, client *rpc_client, block_number int) (bool, []*evm, error) {
+	block, err := client.block_by_number(uint64(block_number))
+	if err != nil {
+		return false, nil, err
+	}
+	if block.NumberU64() == 0 {
+		return false, nil, fmt.Errorf("genesis block has no parent state")
+	}
+
+	chainCfg, _, _ := tests.GetChainConfig("London")
+	reader := new_rpc_reader(client, block.NumberU64()-1)
+	if err := reader.prefetch(block, chainCfg); err != nil {
+		return false, nil, err
+	}
+
+	results, err := analize_state(ctx, block, reader, chainCfg, client.get_hash(), *TX_WORKERS)
+	if err != nil {
+		return false, nil, err
+	}
+	return handle_results(results, block_number), results, nil
+}
+
+// analyses block_number fetched from the node at url, with -snapshot the
+// snapshot of the block is written as well
+func analize_block_rpc(ctx context.Context, url, cache_dir string, block_number int, snapshot_path string) bool {
+	client := new_rpc_client(url, cache_dir)
+
+	if snapshot_path == "" {
+		result, results, err := rpc_analize(ctx, client, block_number)
+		if err != nil {
+			log.Fatal(err)
+		}
+		block, err := client.block_by_number(uint64(block_number)) // cached
+		if err != nil {
+			log.Fatal(err)
+		}
+		print_results(block, result, results)
+		return result
+	}
+
+	block, err := client.block_by_number(uint64(block_number))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if block.NumberU64() == 0 {
+		log.Fatal("genesis block has no parent state")
+	}
+	chainCfg, _, _ := tests.GetChainConfig("London")
+	reader := new_rpc_reader(client, block.NumberU64()-1)
+	if err := reader.prefetch(block, chainCfg); err != nil {
+		log.Fatal(err)
+	}
+	result, results, snap, err := capture(ctx, block, reader, client.get_hash())
+	if err != nil {
+		log.Fatal(err)
+	}
+	print_results(block, result, results)
+	if err := write_snapshot(snapshot_path, snap); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Snapshot of block #%d written to %s (%d accounts)\n", block_number, snapshot_path, len(snap.Prestate))
+	return result
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+	"github.com/ledgerwatch/erigon/core"
+)
+
+// node serving a single block of a fixture on top of its genesis
+type stub_node struct {
+	blocks map[string]json.RawMessage // block number -> eth_getBlockByNumber result
+	alloc  core.GenesisAlloc
+
+	mu      sync.Mutex
+	posts   int // HTTP requests
+	batches []int
+
+	hold chan struct{} // if set, requests are answered once it is closed
+}
+
+func new_stub_node(t *testing.T, f *chain_fixture) *stub_node {
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(block_json(t, f, 0), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return &stub_node{
+		blocks: map[string]json.RawMessage{"0x1": resp.Result},
+		alloc:  f.alloc(),
+	}
+}
+
+func (n *stub_node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var reqs []struct {
+		ID     int               `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n.mu.Lock()
+	n.posts++
+	n.batches = append(n.batches, len(reqs))
+	n.mu.Unlock()
+	if n.hold != nil {
+		<-n.hold
+	}
+
+	var out []map[string]interface{}
+	for _, req := range reqs {
+		result, err := n.answer(req.Method, req.Params)
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		if err != nil {
+			resp["error"] = map[string]interface{}{"code": -32000, "message": err.Error()}
+		} else {
+			resp["result"] = result
+		}
+		out = append(out, resp)
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func (n *stub_node) answer(method string, params []json.RawMessage) (interface{}, error) {
+	var number string
+	if method == "eth_getBlockByNumber" {
+		json.Unmarshal(params[0], &number)
+		if number == "0x0" {
+			return map[string]interface{}{"hash": common.Hash{}}, nil
+		}
+		block, ok := n.blocks[number]
+		if !ok {
+			return nil, nil
+		}
+		return block, nil
+	}
+
+	var addr common.Address
+	json.Unmarshal(params[0], &addr)
+	json.Unmarshal(params[len(params)-1], &number)
+	// state is asked at the parent of block 1
+	if number != "0x0" {
+		return nil, fmt.Errorf("state requested at block %s", number)
+	}
+
+	account := n.alloc[addr]
+	switch method {
+	case "eth_getBalance":
+		if account.Balance == nil {
+			return "0x0", nil
+		}
+		return (*hexutil.Big)(account.Balance), nil
+	case "eth_getTransactionCount":
+		return hexutil.Uint64(account.Nonce), nil
+	case "eth_getCode":
+		return hexutil.Bytes(account.Code), nil
+	case "eth_getStorageAt":
+		var key common.Hash
+		json.Unmarshal(params[1], &key)
+		return account.Storage[key], nil
+	}
+	return nil, fmt.Errorf("method %s not supported", method)
+}
+
+func TestRPCBackend(t *testing.T) {
+	f := load_fixture(t, filepath.Join("testdata", "fixtures", "complicated.json"))
+	node := new_stub_node(t, f)
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	cache_dir := t.TempDir()
+	result, results, err := rpc_analize(context.Background(), new_rpc_client(server.URL, cache_dir), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := f.Blocks[0].Expect
+	if result != expect.Independent || len(results) != len(expect.Txs) {
+		t.Fatalf("verdict %t with %d transactions, want %t with %d", result, len(results), expect.Independent, len(expect.Txs))
+	}
+	for i, want := range expect.Txs {
+		f.check_set(t, fmt.Sprintf("tx %d: reads", i), results[i].rw_set.read_set, want.Reads)
+		f.check_set(t, fmt.Sprintf("tx %d: writes", i), results[i].rw_set.write_set, want.Writes)
+	}
+
+	// balance, nonce and code of an account are fetched in one request
+	batched := false
+	for _, size := range node.batches {
+		batched = batched || size == 3
+	}
+	if !batched {
+		t.Errorf("account data was not batched: %v", node.batches)
+	}
+
+	// run one transaction at a time: the block, one batch with the coinbase,
+	// the sender and the four recipients, then one request per account the
+	// calls reach (A to E)
+	if node.posts != 7 || node.batches[1] != 6*3 {
+		t.Errorf("%d requests %v, want 7 with 18 prefetched", node.posts, node.batches)
+	}
+
+	// a second run is served from the on-disk cache only
+	posts := node.posts
+	again, _, err := rpc_analize(context.Background(), new_rpc_client(server.URL, cache_dir), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != result || node.posts != posts {
+		t.Errorf("second run made %d requests, verdict %t", node.posts-posts, again)
+	}
+
+	// storage is read without the lock of the block cache
+	*TX_WORKERS = 4
+	defer func() { *TX_WORKERS = 1 }()
+	concurrent, _, err := rpc_analize(context.Background(), new_rpc_client(server.URL, ""), 1)
+	if err != nil || concurrent != result {
+		t.Errorf("verdict %t with -txworkers=4, want %t (%v)", concurrent, result, err)
+	}
+
+	if _, _, err := rpc_analize(context.Background(), new_rpc_client(server.URL, ""), 2); err == nil {
+		t.Error("missing block should fail")
+	}
+}
+
+func TestRPCStorageBatched(t *testing.T) {
+	f := load_fixture(t, filepath.Join("testdata", "fixtures", "complicated.json"))
+	node := new_stub_node(t, f)
+	node.hold = make(chan struct{})
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	reader := new_rpc_reader(new_rpc_client(server.URL, ""), 0)
+	address := common.HexToAddress("0xc0de")
+	var wg sync.WaitGroup
+	read := func(slot int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := common.BigToHash(big.NewInt(int64(slot)))
+			if _, err := reader.ReadAccountStorage(address, 1, &key); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wait := func(done func() bool) {
+		for !done() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// the reads made while the first one is in flight go out together
+	read(0)
+	wait(func() bool {
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		return node.posts == 1
+	})
+	for slot := 1; slot <= 4; slot++ {
+		read(slot)
+	}
+	wait(func() bool {
+		reader.queue.mu.Lock()
+		defer reader.queue.mu.Unlock()
+		return len(reader.queue.waiting) == 4
+	})
+	close(node.hold)
+	wg.Wait()
+	if !reflect.DeepEqual(node.batches, []int{1, 4}) {
+		t.Errorf("batches %v, want [1 4]", node.batches)
+	}
+
+	// answered from the client once read
+	key := common.BigToHash(big.NewInt(3))
+	if _, err := reader.ReadAccountStorage(address, 1, &key); err != nil || node.posts != 2 {
+		t.Errorf("cached slot read again: %d requests, %v", node.posts, err)
+	}
+}
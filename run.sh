@@ -1,38 +1,11 @@
 #!/bin/sh
+# builds bin/main and runs it with the arguments given:
+#   ./run.sh analyze -chaindata=/path/to/chaindata -block=156893 -graphviz
 
 BIN_DIR=bin
 
-CHAIN_DATA_PATH=/home/kairat/diskC/goerli/erigon/chaindata/
-BLOCK_INDEX=0
-GRAPHVIZ=false
-LOOP=false
-
-for i in "$@"; do
-    case $i in 
-        -p=*|--path=*)
-        CHAIN_DATA_PATH="${i#*=}"
-        shift
-        ;;
-        -b=*|--block=*)
-        BLOCK_INDEX="${i#*=}"
-        shift
-        ;;
-        -g=*|--graphiz=*)
-        GRAPHVIZ="${i#*=}"
-        shift
-        ;;
-        -l=*|--loop=*)
-        LOOP="${i#*=}"
-        shift
-        ;;
-        *)
-        ;;
-    esac
-done
-
-
 mkdir -p $BIN_DIR
 
-go build -o $BIN_DIR/main ./... 
+go build -o $BIN_DIR/main . || exit 1
 
-./$BIN_DIR/main -chaindata=$CHAIN_DATA_PATH -block=$BLOCK_INDEX -graphviz=$GRAPHVIZ -loop=$LOOP
\ No newline at end of file
+exec ./$BIN_DIR/main "$@"
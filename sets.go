@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"os"
+	"sort"
 
 	"github.com/ledgerwatch/erigon/common"
 )
@@ -12,32 +16,130 @@ type rw_set struct {
 	write_set map[common.Address]bool
 	// contains indexes of transactions
 	// that write to the same address as this transaction read
-	cross_set [][2]int
+	cross_set []cross
+}
+
+// transaction txn_idx writes to addr, which this transaction reads or
+// writes (mode)
+type cross struct {
+	txn_idx int
+	mode    int
+	addr    common.Address
 }
 
 func new_set_addr() *rw_set {
 	read_set := make(map[common.Address]bool)
 	write_set := make(map[common.Address]bool)
-	cross_set := make([][2]int, 0)
+	cross_set := make([]cross, 0)
 	return &rw_set{read_set, write_set, cross_set}
 }
 
-func (set *rw_set) add_cross(txn_idx int, write_set *map[common.Address]bool) {
+// an access of an address by transaction tx
+type key_access struct {
+	tx          int
+	read, write bool
+}
 
-	for addr := range *write_set {
-		// if read set of this transaction has an address
-		// that other transaction writes to it
-		if _, ok := set.read_set[addr]; ok {
-			set.cross_set = append(set.cross_set, [2]int{txn_idx, READ})
+// inverted index from address to the transactions accessing it in
+// transaction order, built once per block
+type access_index map[common.Address][]key_access
+
+func new_access_index(results []*evm) access_index {
+	index := make(access_index)
+	for i, _evm := range results {
+		for addr := range _evm.rw_set.read_set {
+			index[addr] = append(index[addr], key_access{tx: i, read: true})
 		}
+		for addr := range _evm.rw_set.write_set {
+			accesses := index[addr]
+			if n := len(accesses); n > 0 && accesses[n-1].tx == i {
+				accesses[n-1].write = true
+			} else {
+				index[addr] = append(accesses, key_access{tx: i, write: true})
+			}
+		}
+	}
+	return index
+}
 
-		// if write set of this transaction has an address
-		// that other transaction writes to it
-		if _, ok := set.write_set[addr]; ok {
-			set.cross_set = append(set.cross_set, [2]int{txn_idx, WRITE})
+// replaces the cross sets of results with the edges ordering the block: a
+// read crosses the last write before it, a write crosses the last write
+// and the reads since. Transactions further apart are ordered through
+// them, so an address every transaction touches gives a linear number of
+// crossings.
+func (index access_index) set_crosses(results []*evm) {
+	for _, _evm := range results {
+		_evm.rw_set.cross_set = _evm.rw_set.cross_set[:0]
+	}
+	add := func(tx int, c cross) {
+		results[tx].rw_set.cross_set = append(results[tx].rw_set.cross_set, c)
+	}
+	var readers []int
+	for addr, accesses := range index {
+		last_writer := -1
+		readers = readers[:0]
+		for _, a := range accesses {
+			if a.read && last_writer >= 0 {
+				add(a.tx, cross{last_writer, READ, addr})
+			}
+			if !a.write {
+				readers = append(readers, a.tx)
+				continue
+			}
+			for _, r := range readers {
+				add(r, cross{a.tx, READ, addr})
+			}
+			if last_writer >= 0 {
+				add(a.tx, cross{last_writer, WRITE, addr})
+				add(last_writer, cross{a.tx, WRITE, addr})
+			}
+			last_writer = a.tx
+			readers = readers[:0]
 		}
 	}
+	for _, _evm := range results {
+		sort_crosses(_evm.rw_set.cross_set)
+	}
+}
+
+// sorts by transaction, address and mode
+func sort_crosses(crosses []cross) {
+	sort.Slice(crosses, func(i, j int) bool {
+		a, b := crosses[i], crosses[j]
+		if a.txn_idx != b.txn_idx {
+			return a.txn_idx < b.txn_idx
+		}
+		if cmp := bytes.Compare(a.addr[:], b.addr[:]); cmp != 0 {
+			return cmp < 0
+		}
+		return a.mode < b.mode
+	})
+}
 
+// pairs i < j of transactions the cross sets order, j has to run after i;
+// sorted by j, then i
+func ordered_pairs(results []*evm) [][2]int {
+	seen := make(map[[2]int]bool)
+	var pairs [][2]int
+	for x, _evm := range results {
+		for _, c := range _evm.rw_set.cross_set {
+			p := [2]int{x, c.txn_idx}
+			if p[0] > p[1] {
+				p[0], p[1] = p[1], p[0]
+			}
+			if !seen[p] {
+				seen[p] = true
+				pairs = append(pairs, p)
+			}
+		}
+	}
+	sort.Slice(pairs, func(a, b int) bool {
+		if pairs[a][1] != pairs[b][1] {
+			return pairs[a][1] < pairs[b][1]
+		}
+		return pairs[a][0] < pairs[b][0]
+	})
+	return pairs
 }
 
 func (set *rw_set) add(addr common.Address, mode int) {
@@ -73,28 +175,44 @@ func (set *rw_set) has(addr common.Address, mode int) bool {
 }
 
 func (set *rw_set) print(idx int) {
-	fmt.Printf("\n**** transaction: %d ****\n", idx)
-	fmt.Println("read set: ")
+	set.write(os.Stdout, idx)
+}
+
+// writes both sets, addresses sorted
+func (set *rw_set) write(w io.Writer, idx int) {
+	fmt.Fprintf(w, "\n**** transaction: %d ****\n", idx)
+	fmt.Fprintln(w, "read set: ")
 	if len(set.read_set) > 0 {
-		for addr := range set.read_set {
-			fmt.Println(addr)
+		for _, addr := range sorted_addresses(set.read_set) {
+			fmt.Fprintln(w, addr)
 		}
 	} else {
-		fmt.Println("-- empty --")
+		fmt.Fprintln(w, "-- empty --")
 	}
-	fmt.Println()
-	fmt.Println("write set: ")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "write set: ")
 
 	if len(set.write_set) > 0 {
-		for addr := range set.write_set {
-			fmt.Println(addr)
+		for _, addr := range sorted_addresses(set.write_set) {
+			fmt.Fprintln(w, addr)
 		}
 	} else {
-		fmt.Println("-- empty --")
+		fmt.Fprintln(w, "-- empty --")
 	}
 
 }
 
+func sorted_addresses(set map[common.Address]bool) []common.Address {
+	addrs := make([]common.Address, 0, len(set))
+	for addr := range set {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+	return addrs
+}
+
 /* ---------------------------------------------------- */
 
 // container of unique byte slices for each exec frame
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common"
+)
+
+// the crosses of every transaction, found pair by pair: a read crosses the
+// last write before it, a write the last write and the reads since
+func pairwise_crosses(results []*evm) [][]cross {
+	// a transaction between i and j writes addr
+	written_between := func(addr common.Address, i, j int) bool {
+		for k := i + 1; k < j; k++ {
+			if results[k].rw_set.has(addr, WRITE) {
+				return true
+			}
+		}
+		return false
+	}
+	crosses := make([][]cross, len(results))
+	for j, b := range results {
+		for i := 0; i < j; i++ {
+			a := results[i]
+			for _, addr := range sorted_addresses(a.rw_set.write_set) {
+				if written_between(addr, i, j) {
+					continue
+				}
+				if b.rw_set.has(addr, READ) {
+					crosses[j] = append(crosses[j], cross{i, READ, addr})
+				}
+				if b.rw_set.has(addr, WRITE) {
+					crosses[j] = append(crosses[j], cross{i, WRITE, addr})
+					crosses[i] = append(crosses[i], cross{j, WRITE, addr})
+				}
+			}
+			for _, addr := range sorted_addresses(b.rw_set.write_set) {
+				if a.rw_set.has(addr, READ) && !a.rw_set.has(addr, WRITE) && !written_between(addr, i, j) {
+					crosses[i] = append(crosses[i], cross{j, READ, addr})
+				}
+			}
+		}
+	}
+	for i := range crosses {
+		sort_crosses(crosses[i])
+	}
+	return crosses
+}
+
+func TestAccessIndexCrosses(t *testing.T) {
+	results := synthetic_block(300, 400)
+	want := pairwise_crosses(results)
+
+	for run := 0; run < 2; run++ { // handled again, nothing is duplicated
+		if handle_results(results, 0) {
+			t.Error("block with crossing transactions is independent")
+		}
+		for i, _evm := range results {
+			got := _evm.rw_set.cross_set
+			if len(got) != len(want[i]) || (len(got) > 0 && !reflect.DeepEqual(got, want[i])) {
+				t.Fatalf("run %d, tx %d: crosses %v\nwant %v", run, i, got, want[i])
+			}
+		}
+	}
+
+	if !handle_results(synthetic_block(1, 10), 0) {
+		t.Error("single transaction block is not independent")
+	}
+}
+
+// every conflicting pair is ordered through the edges
+func TestAccessIndexOrders(t *testing.T) {
+	results := synthetic_block(200, 300)
+	handle_results(results, 0)
+
+	after := make([]map[int]bool, len(results)) // transactions j runs after
+	for _, p := range ordered_pairs(results) {
+		i, j := p[0], p[1]
+		if after[j] == nil {
+			after[j] = make(map[int]bool)
+		}
+		after[j][i] = true
+		for k := range after[i] {
+			after[j][k] = true
+		}
+	}
+	for j := range results {
+		for i := 0; i < j; i++ {
+			if len(pair_conflicts(results, i, j)) > 0 && !after[j][i] {
+				t.Fatalf("tx %d conflicts with tx %d but is not ordered after it", j, i)
+			}
+		}
+	}
+}
+
+// every transaction reads and writes the same address
+func TestAccessIndexHotAddress(t *testing.T) {
+	results := hot_block(1000)
+	handle_results(results, 0)
+	n := 0
+	for _, _evm := range results {
+		n += len(_evm.rw_set.cross_set)
+	}
+	if n > 3*len(results) {
+		t.Errorf("%d crosses for %d transactions on one address", n, len(results))
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/common/hexutil"
+	"github.com/ledgerwatch/erigon/core/rawdb"
+	"github.com/ledgerwatch/erigon/core/state"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+	"github.com/ledgerwatch/erigon/crypto"
+	"github.com/ledgerwatch/erigon/rlp"
+	"github.com/ledgerwatch/erigon/tests"
+	log_ "github.com/ledgerwatch/log/v3"
+)
+
+// self-contained block fixture: everything the analysis of a block read
+type snapshot struct {
+	Block    hexutil.Bytes          `json:"block"` // RLP encoded block
+	Prestate prestate               `json:"prestate"`
+	Hashes   map[uint64]common.Hash `json:"blockHashes,omitempty"`
+}
+
+// state reader that remembers every item it passes through
+type recording_reader struct {
+	reader   state.StateReader
+	accounts prestate
+}
+
+func new_recording_reader(reader state.StateReader) *recording_reader {
+	return &recording_reader{reader: reader, accounts: make(prestate)}
+}
+
+func (r *recording_reader) account(address common.Address) *prestate_account {
+	acc, ok := r.accounts[address]
+	if !ok {
+		acc = &prestate_account{Balance: new(hexutil.Big)}
+		r.accounts[address] = acc
+	}
+	return acc
+}
+
+func (r *recording_reader) ReadAccountData(address common.Address) (*accounts.Account, error) {
+	data, err := r.reader.ReadAccountData(address)
+	if err != nil || data == nil {
+		return data, err
+	}
+
+	acc := r.account(address)
+	acc.Balance = (*hexutil.Big)(data.Balance.ToBig())
+	acc.Nonce = data.Nonce
+
+	// code is read lazily, but the hash depends on it, so keep it anyway
+	if data.CodeHash != crypto.Keccak256Hash(nil) && acc.Code == nil {
+		code, err := r.reader.ReadAccountCode(address, data.Incarnation, data.CodeHash)
+		if err != nil {
+			return nil, err
+		}
+		acc.Code = code
+	}
+	return data, nil
+}
+
+func (r *recording_reader) ReadAccountStorage(address common.Address, incarnation uint64, key *common.Hash) ([]byte, error) {
+	value, err := r.reader.ReadAccountStorage(address, incarnation, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// an account that does not exist has no storage to record
+	acc, ok := r.accounts[address]
+	if !ok {
+		return value, nil
+	}
+	if acc.Storage == nil {
+		acc.Storage = make(map[common.Hash]common.Hash)
+	}
+	acc.Storage[*key] = common.BytesToHash(value)
+	return value, nil
+}
+
+func (r *recording_reader) ReadAccountCode(address common.Address, incarnation uint64, codeHash common.Hash) ([]byte, error) {
+	code, err := r.reader.ReadAccountCode(address, incarnation, codeHash)
+	if err == nil && len(code) > 0 {
+		r.account(address).Code = code
+	}
+	return code, err
+}
+
+func (r *recording_reader) ReadAccountCodeSize(address common.Address, incarnation uint64, codeHash common.Hash) (int, error) {
+	code, err := r.ReadAccountCode(address, incarnation, codeHash)
+	return len(code), err
+}
+
+func (r *recording_reader) ReadAccountIncarnation(address common.Address) (uint64, error) {
+	return r.reader.ReadAccountIncarnation(address)
+}
+
+// wraps get_hash, remembering every requested hash in hashes
+func recording_get_hash(get_hash func(uint64) common.Hash, hashes map[uint64]common.Hash) func(uint64) common.Hash {
+	return func(n uint64) common.Hash {
+		hash := get_hash(n)
+		hashes[n] = hash
+		return hash
+	}
+}
+
+func write_snapshot(path string, snap *snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func read_snapshot(path string) (*snapshot, *types.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(snap.Block, block); err != nil {
+		return nil, nil, fmt.Errorf("%s: block: %w", path, err)
+	}
+	return &snap, block, nil
+}
+
+// analyses a block with the given reader, returns the verdict, the results
+// and the snapshot of everything that was read
+func capture(ctx context.Context, block *types.Block, reader state.StateReader, get_hash func(uint64) common.Hash) (bool, []*evm, *snapshot, error) {
+	chainCfg, _, _ := tests.GetChainConfig("London")
+
+	rec := new_recording_reader(reader)
+	hashes := make(map[uint64]common.Hash)
+
+	results, err := analize_state(ctx, block, rec, chainCfg, recording_get_hash(get_hash, hashes), *TX_WORKERS)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	result := handle_results(results, int(block.NumberU64()))
+
+	encoded, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	return result, results, &snapshot{Block: encoded, Prestate: rec.accounts, Hashes: hashes}, nil
+}
+
+// replays the analysis of a snapshot, no database required
+func replay(ctx context.Context, snap *snapshot, block *types.Block) (bool, []*evm) {
+	chainCfg, _, _ := tests.GetChainConfig("London")
+	get_hash := func(n uint64) common.Hash { return snap.Hashes[n] }
+
+	results, _ := analize_state(ctx, block, new_alloc_reader(snap.Prestate.alloc()), chainCfg, get_hash, *TX_WORKERS)
+	return handle_results(results, int(block.NumberU64())), results
+}
+
+// reads block_number from chaindata, analyses it and writes the snapshot
+// to path
+func capture_block(ctx context.Context, block_number int, path string) bool {
+	_log := log_.New()
+	db := mdbx.NewMDBX(_log).Path(*CHAINDATA_PATH).MustOpen()
+	defer db.Close()
+
+	tx, err := db.BeginRo(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	block, err := rawdb.ReadBlockByNumber(tx, uint64(block_number))
+	if err != nil {
+		log.Fatalln("Error reading block: ", err)
+	}
+
+	reader := state.NewPlainState(tx, block.NumberU64())
+	result, results, snap, err := capture(ctx, block, reader, canonical_hash(tx))
+	if err != nil {
+		log.Fatal(err)
+	}
+	print_results(block, result, results)
+
+	if err := write_snapshot(path, snap); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Snapshot of block #%d written to %s (%d accounts)\n", block_number, path, len(snap.Prestate))
+	return result
+}
+
+// analyses the block stored in a snapshot file
+func replay_snapshot(ctx context.Context, path string) bool {
+	snap, block, err := read_snapshot(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	result, results := replay(ctx, snap, block)
+	print_results(block, result, results)
+	return result
+}
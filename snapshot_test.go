@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/core"
+	"github.com/racytech/abs_evm/asm"
+)
+
+func TestSnapshotReplay(t *testing.T) {
+	f := load_fixture(t, filepath.Join("testdata", "fixtures", "depend_exec.json"))
+
+	// stores the hash of block 1 at slot 0
+	hasher := common.HexToAddress("0xbb")
+	f.Accounts["H"] = fixture_account{Address: hasher, GenesisAccount: core.GenesisAccount{
+		Code: asm.MustAssemble("PUSH1 1 BLOCKHASH PUSH1 0 SSTORE STOP"),
+	}}
+	f.Blocks = append(f.Blocks, fixture_block{Transactions: []fixture_tx{{From: "alice", To: "H"}}})
+
+	blocks := f.make_blocks(t)
+	for i, block := range blocks {
+		result, results, snap, err := capture(context.Background(), block, new_alloc_reader(f.alloc()), fixture_hashes(blocks))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(t.TempDir(), "snapshot.json")
+		if err := write_snapshot(path, snap); err != nil {
+			t.Fatal(err)
+		}
+		loaded, loaded_block, err := read_snapshot(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded_block.Hash() != block.Hash() {
+			t.Fatalf("block %d: hash changed after decoding", i)
+		}
+
+		// only what was read ends up in the snapshot
+		if _, ok := loaded.Prestate[f.Accounts["C"].Address]; ok {
+			t.Errorf("block %d: unread account C in the snapshot", i)
+		}
+
+		replayed, replayed_results := replay(context.Background(), loaded, loaded_block)
+		if replayed != result {
+			t.Errorf("block %d: replay verdict %t, captured %t", i, replayed, result)
+		}
+		for j := range results {
+			if !reflect.DeepEqual(results[j].rw_set, replayed_results[j].rw_set) {
+				t.Errorf("block %d, tx %d: replayed rw set differs", i, j)
+			}
+		}
+
+		if i == 2 {
+			if loaded.Hashes[1] != blocks[0].Hash() {
+				t.Errorf("block hash 1 not recorded: %v", loaded.Hashes)
+			}
+			var slot common.Hash
+			var value uint256.Int
+			if !replayed_results[0].mstate.get_state(hasher, &slot, &value) || common.Hash(value.Bytes32()) != blocks[0].Hash() {
+				t.Errorf("BLOCKHASH(1) = %x, want %x", value.Bytes32(), blocks[0].Hash())
+			}
+		}
+	}
+}
+
+func TestRecordingReaderMissingAccount(t *testing.T) {
+	r := new_recording_reader(empty_reader{})
+	address := common.HexToAddress("0xdead")
+	if acc, err := r.ReadAccountData(address); acc != nil || err != nil {
+		t.Fatalf("account %v, %v", acc, err)
+	}
+	if _, err := r.ReadAccountStorage(address, 0, &common.Hash{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.accounts[address]; ok {
+		t.Error("storage read made a prestate for an account that does not exist")
+	}
+}
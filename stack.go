@@ -16,8 +16,13 @@ var stackPool = sync.Pool{
 // Stack is an object for basic stack operations. Items popped to the stack are
 // expected to be changed and modified. stack does not take care of adding newly
 // initialised objects.
+//
+// Copies made by _copy share Data until one of them writes to it: every
+// method that changes Data or returns a pointer into it copies Data first
+// when other stacks still share it.
 type Stack struct {
 	Data []uint256.Int
+	refs *int // stacks sharing Data, nil if never copied
 }
 
 func NewStack() *Stack {
@@ -29,11 +34,13 @@ func (st *Stack) Push(d *uint256.Int) {
 	// if st.Len() > 100 {
 	// 	panic("STACK SIZE > 100")
 	// }
+	st.own()
 	st.Data = append(st.Data, *d)
 }
 
 func (st *Stack) PushN(ds ...uint256.Int) {
 	// FIXME: Is there a way to pass args by pointers.
+	st.own()
 	st.Data = append(st.Data, ds...)
 }
 
@@ -48,24 +55,29 @@ func (st *Stack) Cap() int {
 }
 
 func (st *Stack) Swap(n int) {
+	st.own()
 	st.Data[st.Len()-n], st.Data[st.Len()-1] = st.Data[st.Len()-1], st.Data[st.Len()-n]
 }
 
 func (st *Stack) Dup(n int) {
-	st.Push(&st.Data[st.Len()-n])
+	st.own()
+	st.Data = append(st.Data, st.Data[st.Len()-n])
 }
 
 func (st *Stack) Peek() *uint256.Int {
+	st.own()
 	return &st.Data[st.Len()-1]
 }
 
 // Back returns the n'th item in stack
 func (st *Stack) Back(n int) *uint256.Int {
+	st.own()
 	return &st.Data[st.Len()-n-1]
 }
 
 // Returns the n'th item from the beginning
 func (st *Stack) Front(n int) *uint256.Int {
+	st.own()
 	return &st.Data[n]
 }
 
@@ -130,6 +142,25 @@ func (st *ReturnStack) Data() []uint32 {
 	return st.data
 }
 
+// _copy forks the stack, Data is copied by the first write of either side
 func (st *Stack) _copy() *Stack {
-	return &Stack{Data: st.Data}
+	if st.refs == nil {
+		st.refs = new(int)
+		*st.refs = 1
+	}
+	*st.refs++
+	return &Stack{Data: st.Data, refs: st.refs}
+}
+
+// own copies Data if another stack shares it (or did: dropped forks are not
+// released). Popping does not write, a shared stack is popped without a copy.
+func (st *Stack) own() {
+	if st.refs == nil || *st.refs == 1 {
+		return
+	}
+	*st.refs--
+	st.refs = nil
+	data := make([]uint256.Int, len(st.Data), cap(st.Data))
+	copy(data, st.Data)
+	st.Data = data
 }
@@ -6,20 +6,20 @@ import (
 )
 
 const (
-	KNOWN        = 1
-	UNKNOWN      = -1
-	MAX_SIZE int = 100
+	KNOWN   = 1
+	UNKNOWN = -1
 )
 
 var p_stackPool = sync.Pool{
 	New: func() interface{} {
-		return &p_stack{data: make([]int, 0, MAX_SIZE)}
+		return &p_stack{data: make([]int, 0, LIMITS.PStack)}
 	},
 }
 
 // parallel stack - keep tracks of known and unknown values
 type p_stack struct {
 	data []int
+	refs *int // stacks sharing data, copy on write as in Stack
 }
 
 func new_p_stack() *p_stack {
@@ -31,6 +31,7 @@ func (st *p_stack) push(n int) {
 	// if st.Len() > 100 {
 	// 	panic("STACK SIZE > 100")
 	// }
+	st.own()
 	st.data = append(st.data, n)
 }
 
@@ -50,20 +51,24 @@ func (st *p_stack) cap() int {
 }
 
 func (st *p_stack) swap(n int) {
+	st.own()
 	size := st.size()
 	st.data[size-n], st.data[size-1] = st.data[size-1], st.data[size-n]
 }
 
 func (st *p_stack) dup(n int) {
-	st.push(st.data[st.size()-n])
+	st.own()
+	st.data = append(st.data, st.data[st.size()-n])
 }
 
 func (st *p_stack) peek() *int {
+	st.own()
 	return &st.data[st.size()-1]
 }
 
 // Back returns the n'th item in stack
 func (st *p_stack) Back(n int) *int {
+	st.own()
 	return &st.data[st.size()-n-1]
 }
 
@@ -89,5 +94,21 @@ func (st *p_stack) Print() {
 }
 
 func (st *p_stack) _copy() *p_stack {
-	return &p_stack{data: st.data}
+	if st.refs == nil {
+		st.refs = new(int)
+		*st.refs = 1
+	}
+	*st.refs++
+	return &p_stack{data: st.data, refs: st.refs}
+}
+
+func (st *p_stack) own() {
+	if st.refs == nil || *st.refs == 1 {
+		return
+	}
+	*st.refs--
+	st.refs = nil
+	data := make([]int, len(st.data), cap(st.data))
+	copy(data, st.data)
+	st.data = data
 }
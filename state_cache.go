@@ -0,0 +1,143 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/core/state"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+)
+
+type storage_key struct {
+	address     common.Address
+	incarnation uint64
+	key         common.Hash
+}
+
+// state reader shared by transactions analysed concurrently: every item is
+// read once from the underlying reader, which is called under a lock, so
+// it does not have to be safe for concurrent use (chaindata transactions,
+// the recording reader of snapshots). Storage of a concurrent_reader is
+// read without it. Errors are not cached.
+type cached_reader struct {
+	reader state.StateReader
+
+	mu          sync.Mutex
+	accounts    map[common.Address]*accounts.Account
+	storage     map[storage_key][]byte
+	code        map[common.Address][]byte
+	incarnation map[common.Address]uint64
+}
+
+// a reader safe for concurrent use; storage missing from the cache is read
+// from it without the lock, so that it may batch the reads
+type concurrent_reader interface {
+	state.StateReader
+	concurrent()
+}
+
+func new_cached_reader(reader state.StateReader) *cached_reader {
+	return &cached_reader{
+		reader:      reader,
+		accounts:    make(map[common.Address]*accounts.Account),
+		storage:     make(map[storage_key][]byte),
+		code:        make(map[common.Address][]byte),
+		incarnation: make(map[common.Address]uint64),
+	}
+}
+
+// intra block state objects own what they read, every caller gets a copy
+func (r *cached_reader) ReadAccountData(address common.Address) (*accounts.Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	acc, ok := r.accounts[address]
+	if !ok {
+		var err error
+		if acc, err = r.reader.ReadAccountData(address); err != nil {
+			return nil, err
+		}
+		r.accounts[address] = acc
+	}
+	if acc == nil {
+		return nil, nil
+	}
+	return acc.SelfCopy(), nil
+}
+
+func (r *cached_reader) ReadAccountStorage(address common.Address, incarnation uint64, key *common.Hash) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := storage_key{address, incarnation, *key}
+	value, ok := r.storage[k]
+	if !ok {
+		var err error
+		if value, err = r.read_storage(address, incarnation, key); err != nil {
+			return nil, err
+		}
+		r.storage[k] = value
+	}
+	return common.CopyBytes(value), nil
+}
+
+// called and returns with the lock held
+func (r *cached_reader) read_storage(address common.Address, incarnation uint64, key *common.Hash) ([]byte, error) {
+	if _, ok := r.reader.(concurrent_reader); !ok {
+		return r.reader.ReadAccountStorage(address, incarnation, key)
+	}
+	r.mu.Unlock()
+	defer r.mu.Lock()
+	return r.reader.ReadAccountStorage(address, incarnation, key)
+}
+
+// code is never modified, it is shared
+func (r *cached_reader) ReadAccountCode(address common.Address, incarnation uint64, codeHash common.Hash) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.read_code(address, incarnation, codeHash)
+}
+
+func (r *cached_reader) read_code(address common.Address, incarnation uint64, codeHash common.Hash) ([]byte, error) {
+	code, ok := r.code[address]
+	if !ok {
+		var err error
+		if code, err = r.reader.ReadAccountCode(address, incarnation, codeHash); err != nil {
+			return nil, err
+		}
+		r.code[address] = code
+	}
+	return code, nil
+}
+
+func (r *cached_reader) ReadAccountCodeSize(address common.Address, incarnation uint64, codeHash common.Hash) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	code, err := r.read_code(address, incarnation, codeHash)
+	return len(code), err
+}
+
+func (r *cached_reader) ReadAccountIncarnation(address common.Address) (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	inc, ok := r.incarnation[address]
+	if !ok {
+		var err error
+		if inc, err = r.reader.ReadAccountIncarnation(address); err != nil {
+			return 0, err
+		}
+		r.incarnation[address] = inc
+	}
+	return inc, nil
+}
+
+// get_hash usually reads the same database as the reader, it runs under
+// the same lock
+func (r *cached_reader) locked(get_hash func(uint64) common.Hash) func(uint64) common.Hash {
+	return func(n uint64) common.Hash {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return get_hash(n)
+	}
+}
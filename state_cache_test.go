@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/core"
+	"github.com/ledgerwatch/erigon/core/state"
+	"github.com/ledgerwatch/erigon/core/types/accounts"
+	"github.com/ledgerwatch/erigon/tests"
+)
+
+// counts the reads reaching the reader it wraps
+type counting_reader struct {
+	state.StateReader
+	reads int
+}
+
+func (r *counting_reader) ReadAccountData(address common.Address) (*accounts.Account, error) {
+	r.reads++
+	return r.StateReader.ReadAccountData(address)
+}
+
+func (r *counting_reader) ReadAccountStorage(address common.Address, incarnation uint64, key *common.Hash) ([]byte, error) {
+	r.reads++
+	return r.StateReader.ReadAccountStorage(address, incarnation, key)
+}
+
+func TestAnalizeStateParallel(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "fixtures", "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatal("no fixtures found", err)
+	}
+	chainCfg, _, _ := tests.GetChainConfig("London")
+
+	for _, path := range paths {
+		f := load_fixture(t, path)
+		blocks := f.make_blocks(t)
+		for _, block := range blocks {
+			var outputs [2][]byte
+			for i, workers := range []int{1, 4} {
+				results, err := analize_state(context.Background(), block, new_alloc_reader(f.alloc()), chainCfg, fixture_hashes(blocks), workers)
+				if err != nil {
+					t.Fatal(err)
+				}
+				result := handle_results(results, int(block.NumberU64()))
+				if outputs[i], err = json.Marshal(new_block_output(block, result, results)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(outputs[0]) != string(outputs[1]) {
+				t.Errorf("%s, block %d: parallel analysis differs\nsequential %s\nparallel   %s",
+					filepath.Base(path), block.NumberU64(), outputs[0], outputs[1])
+			}
+		}
+	}
+}
+
+func TestCachedReader(t *testing.T) {
+	addr := common.HexToAddress("0x0a")
+	key := common.HexToHash("0x01")
+	alloc := core.GenesisAlloc{addr: {
+		Balance: big.NewInt(1),
+		Storage: map[common.Hash]common.Hash{key: common.HexToHash("0x02")},
+	}}
+	counting := &counting_reader{StateReader: new_alloc_reader(alloc)}
+	cache := new_cached_reader(counting)
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.ReadAccountData(addr); err != nil {
+			t.Fatal(err)
+		}
+		value, err := cache.ReadAccountStorage(addr, 0, &key)
+		if err != nil || common.BytesToHash(value) != common.HexToHash("0x02") {
+			t.Fatalf("storage %x, %v", value, err)
+		}
+	}
+	if counting.reads != 2 {
+		t.Errorf("%d reads reached the reader, want 2", counting.reads)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+	"github.com/ledgerwatch/erigon/common"
+	log_ "github.com/ledgerwatch/log/v3"
+)
+
+// results of analysed blocks, block number (8 bytes big endian) -> block
+// output as json. A block is stored in its own transaction once it is
+// analysed, so a range scan killed at any point resumes where it stopped.
+const RESULTS_TABLE = "AbsResults"
+
+func open_store(path string) (kv.RwDB, error) {
+	return mdbx.NewMDBX(log_.New()).Path(path).WithTablessCfg(func(kv.TableCfg) kv.TableCfg {
+		return kv.TableCfg{RESULTS_TABLE: {}}
+	}).Open()
+}
+
+func block_key(n uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n)
+	return key
+}
+
+func store_put(db kv.RwDB, out block_output) error {
+	data, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	return db.Update(context.Background(), func(tx kv.RwTx) error {
+		return tx.Put(RESULTS_TABLE, block_key(out.Block), data)
+	})
+}
+
+func store_has(db kv.RoDB, n uint64) (has bool, err error) {
+	err = db.View(context.Background(), func(tx kv.Tx) error {
+		has, err = tx.Has(RESULTS_TABLE, block_key(n))
+		return err
+	})
+	return has, err
+}
+
+// nil when block n is not stored
+func store_get(db kv.RoDB, n uint64) (out *block_output, err error) {
+	err = db.View(context.Background(), func(tx kv.Tx) error {
+		data, err := tx.GetOne(RESULTS_TABLE, block_key(n))
+		if err != nil || data == nil {
+			return err
+		}
+		out = new(block_output)
+		return json.Unmarshal(data, out)
+	})
+	return out, err
+}
+
+// ForEach stops early on an error only
+var stop_walk = errors.New("stop")
+
+// calls walk for the stored blocks from..to in order, to < 0 means up to
+// the last stored block
+func walk_range(db kv.RoDB, from uint64, to int64, walk func(out block_output) error) error {
+	err := db.View(context.Background(), func(tx kv.Tx) error {
+		return tx.ForEach(RESULTS_TABLE, block_key(from), func(k, v []byte) error {
+			n := binary.BigEndian.Uint64(k)
+			if to >= 0 && n > uint64(to) {
+				return stop_walk
+			}
+			var out block_output
+			if err := json.Unmarshal(v, &out); err != nil {
+				return fmt.Errorf("block %d: %w", n, err)
+			}
+			return walk(out)
+		})
+	})
+	if err == stop_walk {
+		return nil
+	}
+	return err
+}
+
+// -verdict filter of query
+const (
+	VERDICT_INDEPENDENT = "independent"
+	VERDICT_DEPENDENT   = "dependent" // analysed, but transactions conflict
+	VERDICT_FAILED      = "failed"    // the analysis of a transaction failed
+)
+
+func check_verdict(verdict string) error {
+	switch verdict {
+	case "", VERDICT_INDEPENDENT, VERDICT_DEPENDENT, VERDICT_FAILED:
+		return nil
+	}
+	return fmt.Errorf("unknown verdict %q, expected independent, dependent or failed", verdict)
+}
+
+func failed_txs(out block_output) int {
+	n := 0
+	for _, tx := range out.Transactions {
+		if tx.Status != "ok" {
+			n++
+		}
+	}
+	return n
+}
+
+func block_verdict(out block_output) string {
+	if out.Independent {
+		return VERDICT_INDEPENDENT
+	}
+	if failed_txs(out) > 0 {
+		return VERDICT_FAILED
+	}
+	return VERDICT_DEPENDENT
+}
+
+// true when a transaction of the block reads or writes addr
+func touches(out block_output, addr common.Address) bool {
+	for _, tx := range out.Transactions {
+		for _, set := range [][]common.Address{tx.Reads, tx.Writes} {
+			for _, a := range set {
+				if a == addr {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+type query struct {
+	from    uint64
+	to      int64 // < 0: no upper bound
+	verdict string
+	touches *common.Address
+}
+
+func (q query) match(out block_output) bool {
+	if q.verdict != "" && block_verdict(out) != q.verdict {
+		return false
+	}
+	return q.touches == nil || touches(out, *q.touches)
+}
+
+// output of query, over the blocks matching the filters
+type store_summary struct {
+	Version     int    `json:"version"`
+	Blocks      int    `json:"blocks"`
+	First       uint64 `json:"first"`
+	Last        uint64 `json:"last"`
+	Missing     uint64 `json:"missing"` // blocks between first and last that are not stored
+	Independent int    `json:"independent"`
+	Dependent   int    `json:"dependent"`
+	Failed      int    `json:"failed"`
+	Txs         int    `json:"txs"`
+	FailedTxs   int    `json:"failedTxs"`
+}
+
+func new_store_summary(db kv.RoDB, q query) (store_summary, error) {
+	s := store_summary{Version: OUTPUT_VERSION}
+	err := walk_range(db, q.from, q.to, func(out block_output) error {
+		if !q.match(out) {
+			return nil
+		}
+		if s.Blocks == 0 {
+			s.First = out.Block
+		}
+		s.Blocks++
+		s.Last = out.Block
+		s.Txs += len(out.Transactions)
+		s.FailedTxs += failed_txs(out)
+		switch block_verdict(out) {
+		case VERDICT_INDEPENDENT:
+			s.Independent++
+		case VERDICT_DEPENDENT:
+			s.Dependent++
+		default:
+			s.Failed++
+		}
+		return nil
+	})
+	if s.Blocks > 0 && q.verdict == "" && q.touches == nil {
+		s.Missing = s.Last - s.First + 1 - uint64(s.Blocks)
+	}
+	return s, err
+}
+
+func write_store_summary(w io.Writer, format string, s store_summary) error {
+	if format != FORMAT_TEXT {
+		return write_json(w, format, s)
+	}
+	if s.Blocks == 0 {
+		fmt.Fprintln(w, "no blocks stored")
+		return nil
+	}
+	fmt.Fprintf(w, "blocks %d (#%d..#%d, %d missing)\n", s.Blocks, s.First, s.Last, s.Missing)
+	fmt.Fprintf(w, "independent %d, dependent %d, failed %d\n", s.Independent, s.Dependent, s.Failed)
+	fmt.Fprintf(w, "transactions %d, failed %d\n", s.Txs, s.FailedTxs)
+	return nil
+}
+
+// text form of a stored block, the sets as analize prints them
+func write_stored_block(w io.Writer, format string, out block_output) error {
+	if format != FORMAT_TEXT {
+		return write_json(w, format, out)
+	}
+	fmt.Fprintf(w, "\nIndependent execution for block #%d: %t\n", out.Block, out.Independent)
+	fmt.Fprintln(w, "Number of transactions: ", len(out.Transactions))
+	for _, tx := range out.Transactions {
+		fmt.Fprintf(w, "\n**** transaction: %d ****\n", tx.Index)
+		for _, set := range []struct {
+			name  string
+			addrs []common.Address
+		}{{"read set", tx.Reads}, {"write set", tx.Writes}} {
+			fmt.Fprintf(w, "%s: \n", set.name)
+			for _, a := range set.addrs {
+				fmt.Fprintln(w, a.Hex())
+			}
+			fmt.Fprintln(w)
+		}
+		for _, c := range tx.Conflicts {
+			fmt.Fprintf(w, "conflict: tx %d, %s\n", c.Tx, c.Mode)
+		}
+		if tx.Status != "ok" {
+			fmt.Fprintln(w, "analysis failed:", tx.FailReason)
+		}
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+// one line per block in text
+func write_block_line(w io.Writer, format string, out block_output) error {
+	if format != FORMAT_TEXT {
+		return write_json(w, format, out)
+	}
+	_, err := fmt.Fprintf(w, "#%d %s txs %d %s\n", out.Block, out.Hash.Hex(), len(out.Transactions), block_verdict(out))
+	return err
+}
+
+// query command: the stored block -block, the blocks matching the filters
+// with -list, a summary of them otherwise
+func run_query(path string, q query, block int, list bool) {
+	db, err := open_store(path)
+	if err != nil {
+		log.Fatal("Error opening results store: ", err)
+	}
+	defer db.Close()
+
+	if block >= 0 {
+		out, err := store_get(db, uint64(block))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if out == nil {
+			log.Fatalf("Block %d is not stored\n", block)
+		}
+		if err := write_stored_block(os.Stdout, *FORMAT, *out); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if list {
+		err = walk_range(db, q.from, q.to, func(out block_output) error {
+			if !q.match(out) {
+				return nil
+			}
+			return write_block_line(os.Stdout, *FORMAT, out)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	s, err := new_store_summary(db, q)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := write_store_summary(os.Stdout, *FORMAT, s); err != nil {
+		log.Fatal(err)
+	}
+}
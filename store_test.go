@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common"
+)
+
+func TestResultsStore(t *testing.T) {
+	db, err := open_store(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	token := common.HexToAddress("0x0a")
+	blocks := []block_output{
+		{Version: OUTPUT_VERSION, Block: 10, Independent: true, Transactions: []tx_output{
+			{Index: 0, Status: "ok", Reads: []common.Address{token}, Writes: []common.Address{}, Conflicts: []conflict_output{}},
+		}},
+		{Version: OUTPUT_VERSION, Block: 11, Transactions: []tx_output{
+			{Index: 0, Status: "ok", Reads: []common.Address{}, Writes: []common.Address{token}, Conflicts: []conflict_output{}},
+			{Index: 1, Status: "ok", Reads: []common.Address{token}, Writes: []common.Address{}, Conflicts: []conflict_output{{Tx: 0, Mode: "read"}}},
+		}},
+		{Version: OUTPUT_VERSION, Block: 13, Transactions: []tx_output{
+			{Index: 0, Status: "failed", FailReason: FAIL_LOOP, Reads: []common.Address{}, Writes: []common.Address{}, Conflicts: []conflict_output{}},
+		}},
+	}
+	for _, out := range blocks {
+		if err := store_put(db, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for n, want := range map[uint64]bool{10: true, 11: true, 12: false, 13: true} {
+		has, err := store_has(db, n)
+		if err != nil || has != want {
+			t.Errorf("block %d stored %t (%v), want %t", n, has, err, want)
+		}
+	}
+	out, err := store_get(db, 11)
+	if err != nil || out == nil {
+		t.Fatalf("block 11: %v, %v", out, err)
+	}
+	if len(out.Transactions) != 2 || out.Transactions[1].Conflicts[0] != (conflict_output{Tx: 0, Mode: "read"}) {
+		t.Errorf("block 11 read back as %+v", *out)
+	}
+	if out, err := store_get(db, 12); out != nil || err != nil {
+		t.Errorf("block 12: %v, %v, want not stored", out, err)
+	}
+
+	s, err := new_store_summary(db, query{to: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := store_summary{Version: OUTPUT_VERSION, Blocks: 3, First: 10, Last: 13, Missing: 1,
+		Independent: 1, Dependent: 1, Failed: 1, Txs: 4, FailedTxs: 1}
+	if s != want {
+		t.Errorf("summary %+v, want %+v", s, want)
+	}
+
+	for _, c := range []struct {
+		q    query
+		want []uint64
+	}{
+		{query{from: 11, to: -1}, []uint64{11, 13}},
+		{query{from: 0, to: 11}, []uint64{10, 11}},
+		{query{to: -1, verdict: VERDICT_DEPENDENT}, []uint64{11}},
+		{query{to: -1, touches: &token}, []uint64{10, 11}},
+	} {
+		var got []uint64
+		err := walk_range(db, c.q.from, c.q.to, func(out block_output) error {
+			if c.q.match(out) {
+				got = append(got, out.Block)
+			}
+			return nil
+		})
+		if err != nil || len(got) != len(c.want) {
+			t.Errorf("query %+v: blocks %v (%v), want %v", c.q, got, err, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("query %+v: blocks %v, want %v", c.q, got, c.want)
+				break
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	if err := write_block_line(&b, FORMAT_TEXT, blocks[2]); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(b.String(), "#13 ") || !strings.HasSuffix(b.String(), "txs 1 failed\n") {
+		t.Errorf("line %q", b.String())
+	}
+}
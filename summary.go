@@ -0,0 +1,190 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon/common"
+)
+
+// Calls repeated with the same code, input and value (balanceOf in a loop,
+// many transactions calling the same router) are explored once per block:
+// what the frame accessed, wrote to the mock state and may return is kept
+// in a summary, replayed by the next calls as long as nothing the frame
+// read from the mock state has changed. Frames read the state before the
+// block otherwise, which does not change.
+
+// everything a frame depends on besides the mock state
+type summary_key struct {
+	code_hash common.Hash
+	address   common.Address // storage context
+	caller    common.Address
+	origin    common.Address
+	gasprice  string
+	op        byte // CALL, CALLCODE, DELEGATECALL or STATICCALL
+	level     int  // nested frames fail when too deep
+	value     uint256.Int
+	input     string
+}
+
+// a storage slot or, when balance is set, the balance of address
+type mock_key struct {
+	address common.Address
+	slot    common.Hash
+	balance bool
+}
+
+type mock_write struct {
+	key   mock_key
+	value uint256.Int // the storage value or the balance added
+}
+
+// an access made by the frame or a frame it called
+type summary_access struct {
+	frames []frame // the calls below the frame leading to the access
+	pc     uint64
+	op     byte
+	addr   common.Address
+	mode   int
+}
+
+type frame_summary struct {
+	base     int                 // index of the frame in evm.frames while recording
+	reads    map[mock_key]uint64 // mock state read before writing it, and its version
+	written  map[mock_key]bool
+	writes   []mock_write
+	accesses []summary_access
+	returns  [][]byte // possible return values
+	suicide  bool
+}
+
+// summaries of the frames run in a block, safe for concurrent use
+type summary_cache struct {
+	mu    sync.Mutex
+	items map[summary_key][]*frame_summary
+	hits  int
+}
+
+// mock state versions are unique over all transactions, a summary made
+// against the mock state of one transaction is only valid in another one
+// when it read nothing the first had written
+var mock_versions uint64
+
+func next_mock_version() uint64 {
+	return atomic.AddUint64(&mock_versions, 1)
+}
+
+// a cache for the transactions of a block, nil when the summaries are off
+// or every frame has to run (-coverage, the graph mode)
+func new_summary_cache() *summary_cache {
+	if !*SUMMARIES || *COVERAGE || explorer_mode() == EXPLORER_GRAPH {
+		return nil
+	}
+	return &summary_cache{items: make(map[summary_key][]*frame_summary)}
+}
+
+func (c *summary_cache) get(key summary_key, m *mock_state) *frame_summary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.items[key] {
+		if s.valid(m) {
+			c.hits++
+			return s
+		}
+	}
+	return nil
+}
+
+func (c *summary_cache) put(key summary_key, s *frame_summary) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = append(c.items[key], s)
+}
+
+func (s *frame_summary) valid(m *mock_state) bool {
+	for k, version := range s.reads {
+		if m.version[k] != version {
+			return false
+		}
+	}
+	return true
+}
+
+func (evm *evm) summary_key(contract *Contract, input []byte, op byte) summary_key {
+	return summary_key{
+		code_hash: contract.CodeHash,
+		address:   contract.Address(),
+		caller:    contract.Caller(),
+		origin:    evm.origin,
+		gasprice:  evm.gasprice.String(),
+		op:        op,
+		level:     evm.level,
+		value:     *contract.value,
+		input:     string(input),
+	}
+}
+
+// the message call frame just entered runs, or is replayed from its summary
+func (evm *evm) run_call(contract *Contract, input []byte, op byte) {
+	if evm.summaries == nil || evm.abort {
+		evm.explore(contract, input)
+		return
+	}
+
+	key := evm.summary_key(contract, input, op)
+	if s := evm.summaries.get(key, evm.mstate); s != nil {
+		evm.replay(s)
+		return
+	}
+
+	s := &frame_summary{
+		base:    len(evm.frames) - 1,
+		reads:   make(map[mock_key]uint64),
+		written: make(map[mock_key]bool),
+	}
+	evm.mstate.recording = append(evm.mstate.recording, s)
+	evm.explore(contract, input)
+	evm.mstate.recording = evm.mstate.recording[:len(evm.mstate.recording)-1]
+
+	if evm.abort { // not a result to reuse
+		return
+	}
+	for _, data := range evm.return_data.get(evm.level) {
+		s.returns = append(s.returns, common.CopyBytes(data))
+	}
+	s.written = nil
+	evm.summaries.put(key, s)
+}
+
+func (evm *evm) explore(contract *Contract, input []byte) {
+	if evm.explorer == EXPLORER_GRAPH {
+		new_graph(evm, contract, input)
+	}
+	new_tree(evm, contract, input)
+}
+
+// does what the frame did, frames recording around it record it too
+func (evm *evm) replay(s *frame_summary) {
+	for k := range s.reads {
+		evm.mstate.read(k)
+	}
+	for _, w := range s.writes {
+		if w.key.balance {
+			evm.mstate.add_balance(w.key.address, &w.value)
+		} else {
+			evm.mstate.set_state(w.key.address, &w.key.slot, w.value)
+		}
+	}
+	for _, a := range s.accesses {
+		frames := append(evm.frames[:len(evm.frames):len(evm.frames)], a.frames...)
+		evm.access_in(frames, a.pc, a.op, a.addr, a.mode)
+	}
+	if s.suicide {
+		evm.self_destruct()
+	}
+	evm.return_data.renew(evm.level)
+	for _, data := range s.returns {
+		evm.return_data.add(evm.level, data)
+	}
+}
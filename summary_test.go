@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/racytech/abs_evm/asm"
+)
+
+// calls 0x0b twice with the same input, the balance of the word it
+// returns is read after each call
+var call_twice = asm.MustAssemble(`
+	PUSH1 32 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0x0b GAS CALL POP
+	PUSH1 0 MLOAD BALANCE POP
+	PUSH1 32 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0x0b GAS CALL POP
+	PUSH1 0 MLOAD BALANCE POP
+	STOP
+`)
+
+func run_summarized(t *testing.T, callee []byte, storage map[common.Hash]common.Hash, summaries bool) *evm {
+	defer func(s bool) { *SUMMARIES = s }(*SUMMARIES)
+	*SUMMARIES = summaries
+
+	b := common.HexToAddress("0x0b")
+	_evm := run_code(code_run{
+		code:    call_twice,
+		address: common.HexToAddress("0x0a"),
+		pre:     prestate{b: &prestate_account{Code: callee, Storage: storage}},
+	})
+	if !_evm.result {
+		t.Fatalf("analysis failed: %s", _evm.fail_reason)
+	}
+	return _evm
+}
+
+func TestFrameSummaryReused(t *testing.T) {
+	callee := asm.MustAssemble("PUSH1 0 SLOAD PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN")
+	storage := map[common.Hash]common.Hash{{}: common.HexToHash("0x0c")}
+
+	summarized := run_summarized(t, callee, storage, true)
+	explored := run_summarized(t, callee, storage, false)
+	if summarized.summaries.hits != 1 {
+		t.Errorf("%d summaries reused, want 1", summarized.summaries.hits)
+	}
+	if !reflect.DeepEqual(summarized.report.accessPoints, explored.report.accessPoints) {
+		t.Errorf("accesses differ\nsummarized %+v\nexplored   %+v",
+			summarized.report.accessPoints, explored.report.accessPoints)
+	}
+	if len(summarized.report.why(common.HexToAddress("0x0b"), READ)) != 2 {
+		t.Error("the SLOAD of the second call is not reported")
+	}
+}
+
+func TestFrameSummaryInvalidated(t *testing.T) {
+	// returns slot 0 and sets it to 5, the second call returns 5
+	callee := asm.MustAssemble("PUSH1 0 SLOAD PUSH1 0 MSTORE PUSH1 5 PUSH1 0 SSTORE PUSH1 32 PUSH1 0 RETURN")
+
+	_evm := run_summarized(t, callee, nil, true)
+	if _evm.summaries.hits != 0 {
+		t.Errorf("summary reused after the storage it read changed")
+	}
+	for _, addr := range []string{"0x00", "0x05"} {
+		if !_evm.rw_set.has(common.HexToAddress(addr), READ) {
+			t.Errorf("balance of %s was not read", addr)
+		}
+	}
+}
@@ -151,26 +151,6 @@ func reads_writes(bytecode *[]byte, size uint64) (bool, bool) {
 }
 
 // Returns slice of booleans that marks every valid jump.
-func make_valid_jumpdests(bytecode *[]byte) []bool {
-	l := len(*bytecode)
-	s := make([]bool, l) // slice of valid jumpdests
-
-	for i := 0; i < l; {
-		opcode := (*bytecode)[i]
-
-		if opcode >= 0x60 && opcode <= 0x7F { // PUSH instractions
-			takes := opcode - 0x5F // how many bytes PUSH takes?
-			i += int(takes)
-		} else if opcode == 0x5B { // JUMPDEST
-			s[i] = true
-		}
-
-		i += 1 // opcode itself
-	}
-
-	return s
-}
-
 type tree struct {
 	root *node
 }
@@ -199,7 +179,7 @@ func new_tree(evm *evm, contract *Contract, input []byte) {
 		return
 	}
 
-	valid_jumpdests := make_valid_jumpdests(&bytecode)
+	valid_jumpdests := code_analyses.get(contract.CodeHash, bytecode).jumpdests
 
 	contract.Input = input
 
@@ -209,27 +189,31 @@ func new_tree(evm *evm, contract *Contract, input []byte) {
 		contract: contract,
 	}
 
-	seen := make(map[uint64]bool)
+	cov := new_coverage(evm, contract)
+	evm.coverage = append(evm.coverage, cov)
 
-	new_node(evm, ctx, ROOT_PARENT, 0, &valid_jumpdests, &bytecode, &code_size, &seen)
+	new_node(evm, ctx, ROOT_PARENT, 0, &valid_jumpdests, &bytecode, &code_size, cov)
 
 }
 
-func new_node(evm *evm, ctx *callCtx, parent, pc uint64, valid_jumpdests *[]bool, bytecode *[]byte, code_size *uint64, seen *map[uint64]bool) {
+func new_node(evm *evm, ctx *callCtx, parent, pc uint64, valid_jumpdests *[]bool, bytecode *[]byte, code_size *uint64, cov *coverage) {
 
-	if evm.level > 4 { // 4 recursions, so abort
-		evm.abort = true
-		evm.result = false
+	if evm.level > evm.limits.Depth { // too deep, so abort
+		evm.fail(FAIL_DEPTH)
+		return
+	}
+	if evm.abort || !evm.path() {
 		return
 	}
 
 	start := pc
-	if _, ok := (*seen)[start]; !ok {
+	if _, ok := cov.seen[start]; !ok {
 		// we have never executed code starting at 'start' before
-		(*seen)[start] = true // now we have seen this
+		cov.seen[start] = true // now we have seen this
 
 		jump_dest, is_jump := evm.interpreter.run(
 			&pc, ctx, bytecode, code_size)
+		cov.run(start, pc, jump_dest, is_jump)
 		// fmt.Println(jump_dest, is_jump)
 		// next instruction in bytecode.
 		// if bytecode[pc] == JUMPI then 'stop' is starting point of the false
@@ -241,8 +225,7 @@ func new_node(evm *evm, ctx *callCtx, parent, pc uint64, valid_jumpdests *[]bool
 				jump_dest == GAS_UNIT_OVERFLOW ||
 				jump_dest == STACK_OVERFLOW || jump_dest == STACK_UNDERFLOW {
 
-				evm.abort = true
-				evm.result = false
+				evm.fail(err_reason(jump_dest))
 				return
 			}
 		}
@@ -267,19 +250,19 @@ func new_node(evm *evm, ctx *callCtx, parent, pc uint64, valid_jumpdests *[]bool
 					_, new_frame := check_instructions(start, *code_size, bytecode)
 
 					if new_frame { // creates new exec frame in loop
-						evm.abort = true
-						evm.result = false
+						evm.fail(FAIL_LOOP_FRAME)
 						return
 					}
 
 					if (*bytecode)[pc] == JUMPI && have_code {
 						stop, success := handle_loop(evm, ctx, start, *code_size, bytecode)
+						cov.branch(pc, true)
 						if success {
+							cov.branch(pc, false)
 							ctx_copy := ctx.copy()
-							new_node(evm, ctx_copy, start, stop, valid_jumpdests, bytecode, code_size, seen)
-						} else { // loop made more then 1000 cycles
-							evm.abort = true
-							evm.result = false
+							new_node(evm, ctx_copy, start, stop, valid_jumpdests, bytecode, code_size, cov)
+						} else { // loop made more cycles than allowed
+							evm.fail(FAIL_LOOP)
 							return
 						}
 					}
@@ -289,14 +272,16 @@ func new_node(evm *evm, ctx *callCtx, parent, pc uint64, valid_jumpdests *[]bool
 
 			if stop < *code_size && (*bytecode)[pc] == JUMPI && !is_loop {
 				// JUMPI false condition
+				cov.branch(pc, false)
 				ctx_copy := ctx.copy()
 
-				new_node(evm, ctx_copy, start, stop, valid_jumpdests, bytecode, code_size, seen)
+				new_node(evm, ctx_copy, start, stop, valid_jumpdests, bytecode, code_size, cov)
 			}
 
 			if is_valid_jump && !is_loop {
 				// JUMPI/JUMP true condition or JUMP
-				new_node(evm, ctx, start, jump_dest, valid_jumpdests, bytecode, code_size, seen)
+				cov.branch(pc, true)
+				new_node(evm, ctx, start, jump_dest, valid_jumpdests, bytecode, code_size, cov)
 			}
 		}
 
@@ -316,10 +301,11 @@ func new_node(evm *evm, ctx *callCtx, parent, pc uint64, valid_jumpdests *[]bool
 		// 	 etc
 
 		if skip { // scenario 1
+			cov.skipped[start]++
 
 			if opcode == SELFDESTRUCT { // possible account deletion
 				// repost possible suicide
-				evm.suicide = true
+				evm.self_destruct()
 			}
 
 		} else { // scenarios 2, 3
@@ -329,6 +315,7 @@ func new_node(evm *evm, ctx *callCtx, parent, pc uint64, valid_jumpdests *[]bool
 
 			jump_dest, is_jump := evm.interpreter.run(
 				&_pc, ctx, bytecode, code_size)
+			cov.run(start, _pc, jump_dest, is_jump)
 
 			stop := _pc + 1
 
@@ -338,20 +325,20 @@ func new_node(evm *evm, ctx *callCtx, parent, pc uint64, valid_jumpdests *[]bool
 					jump_dest == GAS_UNIT_OVERFLOW ||
 					jump_dest == STACK_OVERFLOW ||
 					jump_dest == STACK_UNDERFLOW {
-					evm.abort = true
-					evm.result = false
+					evm.fail(err_reason(jump_dest))
 					return
 				}
 			}
 
 			if is_jump {
 				if stop < *code_size && (*bytecode)[_pc] == JUMPI {
+					cov.branch(_pc, false)
 					// if code block ends with JUMPI, did we execute
 					// it for the false condition before?
-					if _, ok := (*seen)[stop]; !ok {
+					if _, ok := cov.seen[stop]; !ok {
 						// we did not
 						left_ctx := ctx.copy()
-						new_node(evm, left_ctx, start, stop, valid_jumpdests, bytecode, code_size, seen)
+						new_node(evm, left_ctx, start, stop, valid_jumpdests, bytecode, code_size, cov)
 					} else {
 						// we did it before
 						// do we need to execute it again?
@@ -359,11 +346,12 @@ func new_node(evm *evm, ctx *callCtx, parent, pc uint64, valid_jumpdests *[]bool
 				}
 
 				if jump_dest < *code_size && (*valid_jumpdests)[jump_dest] {
+					cov.branch(_pc, true)
 					// if we have valid jump, did we execute this before?
-					if _, ok := (*seen)[jump_dest]; !ok {
+					if _, ok := cov.seen[jump_dest]; !ok {
 						// we did not
 						// fmt.Println("WE DID NOT EXECUTE ----> TRUE CONDITION")
-						new_node(evm, ctx, start, jump_dest, valid_jumpdests, bytecode, code_size, seen)
+						new_node(evm, ctx, start, jump_dest, valid_jumpdests, bytecode, code_size, cov)
 					} else {
 						// we did it before
 						// do we need to execute it again?
@@ -376,11 +364,14 @@ func new_node(evm *evm, ctx *callCtx, parent, pc uint64, valid_jumpdests *[]bool
 }
 
 // runs loop and returns the pc for false condition of the stack and true.
-// if more then 100 loop cycles are performed returns 0 and false
+// if more than limits.LoopCycles cycles are performed returns 0 and false
 func handle_loop(evm *evm, ctx *callCtx, start, size uint64, bytecode *[]byte) (uint64, bool) {
 
 	var stop uint64
-	for cycle := 0; cycle < 1000; cycle++ {
+	for cycle := 0; cycle < evm.limits.LoopCycles; cycle++ {
+		if !evm.path() {
+			return 0, false
+		}
 
 		pc := start
 		// execute the code, get the jump destination